@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"sktemporal/model"
@@ -19,128 +22,774 @@ var openDB = sql.Open
 
 // Activities holds dependencies (e.g. config) for Temporal activities.
 type Activities struct {
-	cfg *Config
+	cfg       *Config
+	db        *sql.DB
+	tx        Transactor
+	orders    OrderRepository
+	inventory InventoryRepository
+	users     UserRepository
+	coupons   CouponRepository
+	taxRules  TaxRuleRepository
+	tax       TaxCalculator
+	risk      RiskScorer
+	payments  PaymentGateway
+	carrier   ShippingCarrier
+	rates     ExchangeRateProvider
+}
+
+// ActivitiesOption overrides a default dependency of Activities.
+type ActivitiesOption func(*Activities)
+
+// WithRepositories sets the storage used by the activities instead of the
+// Postgres store, e.g. a MemoryStore in tests.
+func WithRepositories(r Repositories) ActivitiesOption {
+	return func(a *Activities) {
+		a.tx = r.Tx
+		a.orders = r.Orders
+		a.inventory = r.Inventory
+		a.users = r.Users
+		a.coupons = r.Coupons
+		a.taxRules = r.TaxRules
+	}
+}
+
+// WithPaymentGateway sets the gateway used to charge and refund orders.
+func WithPaymentGateway(g PaymentGateway) ActivitiesOption {
+	return func(a *Activities) {
+		a.payments = g
+	}
+}
+
+// WithShippingCarrier sets the carrier used to book and cancel shipments.
+func WithShippingCarrier(c ShippingCarrier) ActivitiesOption {
+	return func(a *Activities) {
+		a.carrier = c
+	}
+}
+
+// WithTaxCalculator sets the calculator used to tax orders instead of the tax_rules table.
+func WithTaxCalculator(c TaxCalculator) ActivitiesOption {
+	return func(a *Activities) {
+		a.tax = c
+	}
+}
+
+// WithRiskScorer sets the scorer used to assess the fraud risk of orders.
+func WithRiskScorer(r RiskScorer) ActivitiesOption {
+	return func(a *Activities) {
+		a.risk = r
+	}
+}
+
+// WithExchangeRates sets the provider used to convert product prices into the order currency.
+func WithExchangeRates(p ExchangeRateProvider) ActivitiesOption {
+	return func(a *Activities) {
+		a.rates = p
+	}
 }
 
 // NewActivities returns an Activities instance with the given config.
-func NewActivities(cfg *Config) *Activities {
-	return &Activities{cfg: cfg}
+// Unless WithRepositories is given, the activities use a Postgres store on a
+// connection pool to the app database shared by every activity invocation.
+// Other dependencies not set through opts default to the in-process fakes and
+// to the tax rules of the repositories and the risk rules of cfg; without WithExchangeRates only orders in
+// the products' own currency can be priced.
+// Call Close when the worker shuts down.
+func NewActivities(cfg *Config, opts ...ActivitiesOption) (*Activities, error) {
+	a := &Activities{
+		cfg:      cfg,
+		payments: NewFakePaymentGateway(),
+		carrier:  NewFakeShippingCarrier(),
+		rates:    &StaticExchangeRates{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.tx == nil {
+		db, err := openDB("postgres", cfg.DBConnectionString())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		maxOpen, maxIdle, maxLifetime := cfg.DBPoolSettings()
+		db.SetMaxOpenConns(maxOpen)
+		db.SetMaxIdleConns(maxIdle)
+		db.SetConnMaxLifetime(maxLifetime)
+
+		a.db = db
+		WithRepositories(NewPostgresStore(db).Repositories())(a)
+	}
+	if a.tax == nil {
+		a.tax = NewRulesTaxCalculator(a.taxRules)
+	}
+	if a.risk == nil {
+		a.risk = NewRulesRiskScorer(cfg.RiskRules(), a.orders, a.users, a.rates)
+	}
+	return a, nil
+}
+
+// Close closes the database connection pool, if the activities own one.
+func (a *Activities) Close() error {
+	if a.db == nil {
+		return nil
+	}
+	return a.db.Close()
+}
+
+// orderIDNamespace is the UUID namespace order IDs are derived in.
+var orderIDNamespace = uuid.MustParse("3f5c2a0e-8d1b-4e6a-9c7f-2b4d6e8a0c13")
+
+// orderIDFor derives the order ID from the request's idempotency key or, when
+// there is none, from the workflow ID. Every attempt of the activity, and every
+// workflow started with the same key, therefore refers to the same order.
+func orderIDFor(ctx context.Context, request model.OrderRequest) uuid.UUID {
+	return newOrderID(activity.GetInfo(ctx).WorkflowExecution.ID, request)
+}
+
+// newOrderID derives the order ID of a request placed by the given workflow.
+// OrderWorkflow uses it to know the order ID before inventory is reserved.
+func newOrderID(workflowID string, request model.OrderRequest) uuid.UUID {
+	if request.IdempotencyKey != "" {
+		return uuid.NewSHA1(orderIDNamespace, []byte("idempotency-key:"+request.IdempotencyKey))
+	}
+	return uuid.NewSHA1(orderIDNamespace, []byte("workflow:"+workflowID))
+}
+
+// newMovement returns the ledger entry for a stock change made by the running activity.
+func newMovement(ctx context.Context, orderID, productID uuid.UUID, delta int, reason string) InventoryMovement {
+	info := activity.GetInfo(ctx)
+	return InventoryMovement{
+		OrderID:    orderID,
+		ProductID:  productID,
+		Delta:      delta,
+		Reason:     reason,
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+	}
+}
+
+// newStatusChange returns the status history entry for a change made by the running activity.
+func newStatusChange(ctx context.Context, orderID uuid.UUID, from, to, reason string) OrderStatusChange {
+	info := activity.GetInfo(ctx)
+	return OrderStatusChange{
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+	}
+}
+
+// transitionOrder moves the order to status in a transaction: it locks the
+// order, rejects transitions the state machine does not allow, runs update to
+// write the status and related columns, and appends the change to the status
+// history. Moving an order to the status it already has is treated as a retry;
+// update runs again but no history is written.
+func (a *Activities) transitionOrder(ctx context.Context, id uuid.UUID, status, reason string, update func(ctx context.Context) error) error {
+	return a.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		order, err := a.orders.GetOrderForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkStatusTransition(order.Status, status); err != nil {
+			return err
+		}
+		if err := update(ctx); err != nil {
+			return err
+		}
+		if order.Status == status {
+			return nil
+		}
+		return a.orders.AppendStatusHistory(ctx, newStatusChange(ctx, id, order.Status, status, reason))
+	})
+}
+
+// startHeartbeat records a heartbeat for the activity at half its heartbeat
+// timeout until the returned func is called. Heartbeats are how a running
+// activity learns it has been cancelled: the server answers one with the
+// cancellation and ctx is then cancelled, which interrupts the gateway and
+// carrier calls. Without a heartbeat timeout nothing is recorded.
+func startHeartbeat(ctx context.Context) (stop func()) {
+	timeout := activity.GetInfo(ctx).HeartbeatTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			activity.RecordHeartbeat(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return cancel
 }
 
 // InventoryResult holds the result of inventory update
 type InventoryResult struct {
-	ProductID        uuid.UUID
-	QuantityDeducted int
-	OrderID          uuid.UUID
+	Items      []model.OrderItem
+	Discounts  []model.Discount
+	TotalPrice model.Money
+	OrderID    uuid.UUID
 }
 
 // PaymentResult holds the result of payment deduction
 type PaymentResult struct {
+	OrderID         uuid.UUID
+	AmountPaid      model.Money
+	AuthorizationID string
+	CaptureID       string
+}
+
+// TaxResult holds the result of tax calculation. TotalPrice is the order total
+// including TaxTotal.
+type TaxResult struct {
 	OrderID    uuid.UUID
-	AmountPaid float64
+	Taxes      []model.TaxLine
+	TaxTotal   model.Money
+	TotalPrice model.Money
+}
+
+// RiskResult holds the result of risk assessment. ApprovalTimeout is how long
+// an order whose Decision is RiskReview waits for manual approval; zero waits
+// until it is approved, rejected or cancelled.
+type RiskResult struct {
+	OrderID         uuid.UUID
+	Score           int
+	Reasons         []string
+	Decision        string
+	ApprovalTimeout time.Duration
+}
+
+// Outcomes of a manual approval
+const (
+	ApprovalApproved = "APPROVED"
+	ApprovalRejected = "REJECTED"
+	ApprovalExpired  = "EXPIRED"
+)
+
+// ApprovalDecision is the outcome of the manual approval of an order, recorded
+// on the order by RecordApprovalActivity. Approver is empty when it expired.
+type ApprovalDecision struct {
+	OrderID  uuid.UUID
+	Decision string
+	Approver string
+	Comment  string `json:",omitempty"`
+}
+
+// ShippingResult holds the result of shipment creation
+type ShippingResult struct {
+	OrderID        uuid.UUID
+	ShipmentID     string
+	TrackingNumber string
+	LabelURL       string
+}
+
+// CompensationResult reports whether a compensation changed anything. Applied
+// is false when the step had already been compensated by an earlier attempt.
+type CompensationResult struct {
+	OrderID uuid.UUID
+	Applied bool
+}
+
+// ValidateUserActivity checks that the user placing the order exists, has a
+// shipping address and is not blocked, and fails with an InvalidUser error otherwise.
+func (a *Activities) ValidateUserActivity(ctx context.Context, userID uuid.UUID) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Validating user", "userID", userID)
+
+	user, err := a.users.GetUser(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return newInvalidUserError(userID, InvalidUserNotFound, "does not exist")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if strings.TrimSpace(user.Address) == "" {
+		return newInvalidUserError(userID, InvalidUserNoAddress, "has no shipping address")
+	}
+	if user.Blocked {
+		return newInvalidUserError(userID, InvalidUserBlocked, "is blocked")
+	}
+
+	logger.Info("User validated successfully")
+	return nil
+}
+
+// checkExistingOrder returns an InvalidOrder error unless the order already
+// stored under the request's order ID is the one the request describes and
+// still holds its stock. It stops a retry from returning another cart's order,
+// or an order whose reservation was already rolled back.
+func checkExistingOrder(existing Order, userID uuid.UUID, items []model.OrderItem, currency string, couponCodes []string) error {
+	if existing.UserID != userID {
+		return newInvalidOrderError("order %s already exists for another user", existing.ID)
+	}
+	if existing.InventoryReleased || existing.Status == StatusCancelled {
+		return newInvalidOrderError("order %s was already rolled back", existing.ID)
+	}
+	quantities := make(map[uuid.UUID]int, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	for _, item := range existing.Items {
+		quantities[item.ProductID] -= item.Quantity
+	}
+	for _, quantity := range quantities {
+		if quantity != 0 {
+			return newInvalidOrderError("order %s already exists with other items", existing.ID)
+		}
+	}
+	if existing.TotalPrice.Currency != currency {
+		return newInvalidOrderError("order %s already exists with currency %s", existing.ID, existing.TotalPrice.Currency)
+	}
+	codes := make(map[string]bool, len(couponCodes))
+	for _, code := range couponCodes {
+		codes[code] = true
+	}
+	if len(existing.Discounts) != len(codes) {
+		return newInvalidOrderError("order %s already exists with other coupons", existing.ID)
+	}
+	for _, discount := range existing.Discounts {
+		if !codes[discount.CouponCode] {
+			return newInvalidOrderError("order %s already exists with other coupons", existing.ID)
+		}
+	}
+	return nil
+}
+
+// mergeOrderLines adds up the quantities of the items ordering the same
+// product into one line per product, in the order the products first appear.
+func mergeOrderLines(items []model.OrderItem) []model.OrderItem {
+	lines := make([]model.OrderItem, 0, len(items))
+	index := make(map[uuid.UUID]int, len(items))
+	for _, item := range items {
+		if i, ok := index[item.ProductID]; ok {
+			lines[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ProductID] = len(lines)
+		lines = append(lines, model.OrderItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return lines
+}
+
+// sortedByProductID returns a copy of lines sorted by product ID, the order
+// in which UpdateInventoryActivity locks products.
+func sortedByProductID(lines []model.OrderItem) []model.OrderItem {
+	sorted := append([]model.OrderItem(nil), lines...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].ProductID[:], sorted[j].ProductID[:]) < 0
+	})
+	return sorted
 }
 
 // Activity 1: Update Inventory
 func (a *Activities) UpdateInventoryActivity(ctx context.Context, request model.OrderRequest) (InventoryResult, error) {
 	logger := activity.GetLogger(ctx)
-	logger.Info("Updating inventory", "productID", request.ProductID, "quantity", request.ProductQuantity)
+	logger.Info("Updating inventory", "userID", request.UserID, "items", len(request.Items))
 
-	db, err := openDB("postgres", a.cfg.DBConnectionString())
+	if len(request.Items) == 0 {
+		return InventoryResult{}, newInvalidOrderError("order has no items")
+	}
+	for _, item := range request.Items {
+		if item.Quantity <= 0 {
+			return InventoryResult{}, newInvalidOrderError("invalid quantity %d for product %s", item.Quantity, item.ProductID)
+		}
+	}
+	currency := request.Currency
+	if currency == "" {
+		currency = model.DefaultCurrency
+	}
+	if !isCurrencyCode(currency) {
+		return InventoryResult{}, newInvalidOrderError("invalid currency %q", request.Currency)
+	}
+	couponCodes, err := normalizeCouponCodes(request.CouponCodes)
 	if err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to connect to database: %w", err)
+		return InventoryResult{}, newInvalidOrderError("%v", err)
 	}
-	defer db.Close()
 
-	// Begin transaction
-	tx, err := db.Begin()
+	lines := mergeOrderLines(request.Items)
+	orderID := orderIDFor(ctx, request)
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	var items []model.OrderItem
+	var discounts []model.Discount
+	var totalPrice model.Money
+	var reused bool
+
+	// Either every item is reserved, every coupon redeemed and the order
+	// created, or nothing is
+	err = a.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		// A previous attempt already committed: return its result instead of
+		// deducting the stock again. An order created by another workflow with
+		// the same idempotency key is not ours to pay for, ship or roll back.
+		existing, err := a.orders.GetOrder(ctx, orderID)
+		if err == nil {
+			if existing.WorkflowID != workflowID {
+				return newDuplicateOrderError(existing)
+			}
+			if err := checkExistingOrder(existing, request.UserID, request.Items, currency, couponCodes); err != nil {
+				return err
+			}
+			items, discounts, totalPrice, reused = existing.Items, existing.Discounts, existing.TotalPrice, true
+			return nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to look up order %s: %w", orderID, err)
+		}
+
+		// Lock the products in ID order so that concurrent orders sharing
+		// products take the locks in the same order and cannot deadlock, and
+		// so that none of them can oversell a product
+		products := make(map[uuid.UUID]Product, len(lines))
+		for _, line := range sortedByProductID(lines) {
+			product, err := a.inventory.GetProductForUpdate(ctx, line.ProductID)
+			if errors.Is(err, ErrNotFound) {
+				return newProductNotFoundError(line.ProductID, err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get product with UUID %s: %w", line.ProductID, err)
+			}
+
+			if product.ItemsAvailable < line.Quantity {
+				return newInsufficientStockError(line.ProductID, product.ItemsAvailable, line.Quantity)
+			}
+
+			// Update inventory
+			if err := a.inventory.AddProductStock(ctx, newMovement(ctx, orderID, line.ProductID, -line.Quantity, MovementOrderReserved)); err != nil {
+				return fmt.Errorf("failed to update inventory: %w", err)
+			}
+			products[line.ProductID] = product
+		}
+
+		items = make([]model.OrderItem, 0, len(lines))
+		totalPrice = model.NewMoney(0, currency)
+		rates := make(map[string]model.ExchangeRate)
+		for _, line := range lines {
+			product := products[line.ProductID]
+			// Convert the unit price first so that unit price × quantity is the line total
+			unitPrice, err := a.convertPrice(ctx, product.Price, currency, rates)
+			if err != nil {
+				err = fmt.Errorf("failed to price product %s in %s: %w", line.ProductID, currency, err)
+				if errors.Is(err, ErrNoExchangeRate) {
+					return newUnsupportedCurrencyError(product.Price.Currency, currency, err)
+				}
+				return err
+			}
+			items = append(items, model.OrderItem{
+				ProductID:   line.ProductID,
+				Quantity:    line.Quantity,
+				UnitPrice:   unitPrice,
+				ProductType: product.Type,
+			})
+			if totalPrice, err = totalPrice.Add(unitPrice.Mul(int64(line.Quantity))); err != nil {
+				return fmt.Errorf("failed to price order: %w", err)
+			}
+		}
+
+		coupons, err := a.loadCoupons(ctx, request.UserID, couponCodes, currency, rates)
+		if err != nil {
+			return err
+		}
+		if discounts, totalPrice, err = computeDiscounts(items, totalPrice, coupons); err != nil {
+			return err
+		}
+
+		// Create order record. A concurrent attempt inserting the same order ID
+		// fails here and rolls back its stock changes.
+		err = a.orders.CreateOrder(ctx, Order{
+			ID:            orderID,
+			UserID:        request.UserID,
+			Items:         items,
+			TotalPrice:    totalPrice,
+			ExchangeRates: sortedRates(rates),
+			Discounts:     discounts,
+			Status:        StatusAddedToCart,
+			WorkflowID:    workflowID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create order: %w", err)
+		}
+		for _, discount := range discounts {
+			err := a.coupons.RecordRedemption(ctx, CouponRedemption{
+				CouponCode: discount.CouponCode,
+				UserID:     request.UserID,
+				OrderID:    orderID,
+				Amount:     discount.Amount,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to redeem coupon %s: %w", discount.CouponCode, err)
+			}
+		}
+		if err := a.orders.AppendStatusHistory(ctx, newStatusChange(ctx, orderID, "", StatusAddedToCart, "inventory reserved")); err != nil {
+			return fmt.Errorf("failed to record order status: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to begin transaction: %w", err)
+		return InventoryResult{}, err
+	}
+
+	if reused {
+		logger.Info("Inventory already updated for order, returning previous result", "orderID", orderID)
+	} else {
+		logger.Info("Inventory updated successfully", "orderID", orderID, "totalPrice", totalPrice, "discounts", len(discounts))
+	}
+	return InventoryResult{
+		Items:      items,
+		Discounts:  discounts,
+		TotalPrice: totalPrice,
+		OrderID:    orderID,
+	}, nil
+}
+
+// convertPrice converts a product price into the order currency. Each rate is
+// fetched once per order and kept in rates so it can be snapshotted on the order.
+func (a *Activities) convertPrice(ctx context.Context, price model.Money, currency string, rates map[string]model.ExchangeRate) (model.Money, error) {
+	if price.Currency == currency {
+		return price, nil
 	}
-	defer tx.Rollback()
+	rate, ok := rates[price.Currency]
+	if !ok {
+		var err error
+		if rate, err = a.rates.Rate(ctx, price.Currency, currency); err != nil {
+			return model.Money{}, err
+		}
+		rates[price.Currency] = rate
+	}
+	return rate.Convert(price)
+}
+
+// loadCoupons locks the coupons for the order, checks that the user may still
+// redeem them, and converts fixed amounts into the order currency.
+func (a *Activities) loadCoupons(ctx context.Context, userID uuid.UUID, codes []string, currency string, rates map[string]model.ExchangeRate) ([]Coupon, error) {
+	coupons := make([]Coupon, 0, len(codes))
+	for _, code := range codes {
+		coupon, err := a.coupons.GetCouponForUpdate(ctx, code)
+		if errors.Is(err, ErrNotFound) {
+			return nil, newInvalidCouponError(code, InvalidCouponNotFound, "does not exist", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get coupon %s: %w", code, err)
+		}
+		if !coupon.Active {
+			return nil, newInvalidCouponError(code, InvalidCouponInactive, "is not active", nil)
+		}
+		if coupon.MaxUsesPerUser > 0 {
+			used, err := a.coupons.CountRedemptions(ctx, code, userID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count redemptions of coupon %s: %w", code, err)
+			}
+			if used >= coupon.MaxUsesPerUser {
+				return nil, newInvalidCouponError(code, InvalidCouponUsageLimit, fmt.Sprintf("already used %d times by user %s", used, userID), nil)
+			}
+		}
+		if coupon.Type == CouponFixedAmount {
+			from := coupon.AmountOff.Currency
+			if coupon.AmountOff, err = a.convertPrice(ctx, coupon.AmountOff, currency, rates); err != nil {
+				err = fmt.Errorf("failed to convert coupon %s into %s: %w", code, currency, err)
+				if errors.Is(err, ErrNoExchangeRate) {
+					return nil, newUnsupportedCurrencyError(from, currency, err)
+				}
+				return nil, err
+			}
+		}
+		coupon.Code = code
+		coupons = append(coupons, coupon)
+	}
+	return coupons, nil
+}
+
+// sortedRates returns the rates ordered by source currency.
+func sortedRates(rates map[string]model.ExchangeRate) []model.ExchangeRate {
+	sorted := make([]model.ExchangeRate, 0, len(rates))
+	for _, rate := range rates {
+		sorted = append(sorted, rate)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+	return sorted
+}
 
-	// Check and update inventory
-	var currentStock int
-	var price float64
-	err = tx.QueryRow(
-		"SELECT items_available, price FROM products WHERE id = $1",
-		request.ProductID,
-	).Scan(&currentStock, &price)
+// isCurrencyCode reports whether s looks like an ISO 4217 code, e.g. "INR".
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Compensation Activity: Release Inventory
+func (a *Activities) ReleaseInventoryActivity(ctx context.Context, result InventoryResult) (CompensationResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Releasing inventory", "orderID", result.OrderID, "items", len(result.Items))
+
+	var applied bool
+	err := a.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		// Flag the order first; if it was already released, leave the stock alone
+		marked, err := a.orders.MarkInventoryReleased(ctx, result.OrderID)
+		if err != nil {
+			return fmt.Errorf("failed to mark inventory released for order %s: %w", result.OrderID, err)
+		}
+		if !marked {
+			return nil
+		}
+
+		// Restore inventory item by item
+		for _, item := range result.Items {
+			if err := a.inventory.AddProductStock(ctx, newMovement(ctx, result.OrderID, item.ProductID, item.Quantity, MovementOrderReleased)); err != nil {
+				logger.Error("Failed to release inventory", "productID", item.ProductID, "error", err)
+				return fmt.Errorf("failed to release inventory for product %s: %w", item.ProductID, err)
+			}
+		}
+		// Give the coupons back so the user can use them on another order
+		if _, err := a.coupons.ReleaseRedemptions(ctx, result.OrderID); err != nil {
+			return fmt.Errorf("failed to release coupons for order %s: %w", result.OrderID, err)
+		}
+		applied = true
+		return nil
+	})
 	if err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to get product with UUID %s: %w", request.ProductID, err)
+		return CompensationResult{}, err
 	}
 
-	if currentStock < request.ProductQuantity {
-		return InventoryResult{}, fmt.Errorf("insufficient stock: available %d, requested %d", currentStock, request.ProductQuantity)
+	if !applied {
+		logger.Info("Inventory already released")
+	} else {
+		logger.Info("Inventory released successfully")
 	}
+	return CompensationResult{OrderID: result.OrderID, Applied: applied}, nil
+}
 
-	// Update inventory
-	newStock := currentStock - request.ProductQuantity
-	_, err = tx.Exec(
-		"UPDATE products SET items_available = $1 WHERE id = $2",
-		newStock,
-		request.ProductID,
-	)
+// CalculateTaxActivity taxes the order with the TaxCalculator, based on the
+// user's address and the product types, and adds the tax to the order total.
+// Once the tax is recorded, later attempts return it without recalculating, so
+// the amount charged cannot change under a retry.
+func (a *Activities) CalculateTaxActivity(ctx context.Context, inventoryResult InventoryResult) (TaxResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Calculating tax", "orderID", inventoryResult.OrderID)
+
+	order, err := a.orders.GetOrder(ctx, inventoryResult.OrderID)
 	if err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to update inventory: %w", err)
+		return TaxResult{}, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if order.TaxCalculated {
+		logger.Info("Tax already calculated for order, returning previous result")
+		return newTaxResult(order)
 	}
 
-	// Create order record
-	productsJSON, _ := json.Marshal(map[string]interface{}{
-		"productID": request.ProductID.String(),
-		"quantity":  request.ProductQuantity,
+	user, err := a.users.GetUser(ctx, order.UserID)
+	if err != nil {
+		return TaxResult{}, fmt.Errorf("failed to get tax address for user %s: %w", order.UserID, err)
+	}
+	lines, err := taxableLines(order.Items, order.Discounts, order.TotalPrice.Currency)
+	if err != nil {
+		return TaxResult{}, fmt.Errorf("failed to split order discounts: %w", err)
+	}
+	taxes, err := a.tax.Calculate(ctx, TaxRequest{
+		OrderID:  order.ID,
+		Address:  user.Address,
+		Currency: order.TotalPrice.Currency,
+		Lines:    lines,
 	})
-	totalPrice := price * float64(request.ProductQuantity)
-
-	orderID := uuid.New()
-	_, err = tx.Exec(
-		`INSERT INTO orders (id, userID, products, total_price, status) 
-		 VALUES ($1, $2, $3, $4, $5)`,
-		orderID,
-		request.UserID,
-		productsJSON,
-		totalPrice,
-		"ADDED_TO_CART",
-	)
 	if err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to create order: %w", err)
+		return TaxResult{}, fmt.Errorf("failed to calculate tax: %w", err)
+	}
+	order.Taxes = taxes
+	result, err := newTaxResult(order)
+	if err != nil {
+		return TaxResult{}, err
 	}
 
-	// Commit transaction
-	if err = tx.Commit(); err != nil {
-		return InventoryResult{}, fmt.Errorf("failed to commit transaction: %w", err)
+	recorded, err := a.orders.RecordTax(ctx, order.ID, taxes, result.TotalPrice)
+	if err != nil {
+		return TaxResult{}, fmt.Errorf("failed to record order tax: %w", err)
+	}
+	if !recorded {
+		// A concurrent attempt got there first; return what it stored
+		if order, err = a.orders.GetOrder(ctx, order.ID); err != nil {
+			return TaxResult{}, fmt.Errorf("failed to fetch order: %w", err)
+		}
+		return newTaxResult(order)
 	}
 
-	logger.Info("Inventory updated successfully", "orderID", orderID)
-	return InventoryResult{
-		ProductID:        request.ProductID,
-		QuantityDeducted: request.ProductQuantity,
-		OrderID:          orderID,
-	}, nil
+	logger.Info("Tax calculated successfully", "taxTotal", result.TaxTotal, "totalPrice", result.TotalPrice)
+	return result, nil
 }
 
-// Compensation Activity: Release Inventory
-func (a *Activities) ReleaseInventoryActivity(ctx context.Context, result InventoryResult) error {
+// newTaxResult returns the TaxResult of an order. Before the tax is recorded,
+// TotalPrice is the pre-tax total and the tax is added to it; afterwards it
+// already includes the tax.
+func newTaxResult(order Order) (TaxResult, error) {
+	taxTotal := model.NewMoney(0, order.TotalPrice.Currency)
+	for _, tax := range order.Taxes {
+		var err error
+		if taxTotal, err = taxTotal.Add(tax.Amount); err != nil {
+			return TaxResult{}, fmt.Errorf("failed to total order tax: %w", err)
+		}
+	}
+	total := order.TotalPrice
+	if !order.TaxCalculated {
+		var err error
+		if total, err = total.Add(taxTotal); err != nil {
+			return TaxResult{}, fmt.Errorf("failed to total order tax: %w", err)
+		}
+	}
+	return TaxResult{OrderID: order.ID, Taxes: order.Taxes, TaxTotal: taxTotal, TotalPrice: total}, nil
+}
+
+// AssessRiskActivity scores the fraud risk of the order with the RiskScorer
+// and decides whether it can be charged, must be reviewed by a person first, or
+// must be rejected.
+func (a *Activities) AssessRiskActivity(ctx context.Context, inventoryResult InventoryResult) (RiskResult, error) {
 	logger := activity.GetLogger(ctx)
-	logger.Info("Releasing inventory", "productID", result.ProductID, "quantity", result.QuantityDeducted)
+	logger.Info("Assessing order risk", "orderID", inventoryResult.OrderID)
 
-	db, err := openDB("postgres", a.cfg.DBConnectionString())
+	order, err := a.orders.GetOrder(ctx, inventoryResult.OrderID)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return RiskResult{}, fmt.Errorf("failed to fetch order: %w", err)
 	}
-	defer db.Close()
-
-	// Restore inventory
-	_, err = db.Exec(
-		"UPDATE products SET items_available = items_available + $1 WHERE id = $2",
-		result.QuantityDeducted,
-		result.ProductID,
-	)
+	score, err := a.risk.Score(ctx, RiskRequest{
+		OrderID:    order.ID,
+		UserID:     order.UserID,
+		Items:      order.Items,
+		TotalPrice: order.TotalPrice,
+	})
 	if err != nil {
-		logger.Error("Failed to release inventory", "error", err)
-		return fmt.Errorf("failed to release inventory: %w", err)
+		return RiskResult{}, fmt.Errorf("failed to score order risk: %w", err)
 	}
 
-	logger.Info("Inventory released successfully")
+	decision := riskDecision(score.Score, a.cfg.RiskReviewScore, a.cfg.RiskRejectScore)
+	logger.Info("Order risk assessed", "score", score.Score, "decision", decision, "reasons", score.Reasons)
+	result := RiskResult{
+		OrderID:  order.ID,
+		Score:    score.Score,
+		Reasons:  score.Reasons,
+		Decision: decision,
+	}
+	if decision == RiskReview {
+		result.ApprovalTimeout = a.cfg.ApprovalTimeout
+	}
+	return result, nil
+}
+
+// RecordApprovalActivity stores the outcome of the order's manual approval and
+// the identity of the approver on the order.
+func (a *Activities) RecordApprovalActivity(ctx context.Context, decision ApprovalDecision) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Recording order approval", "orderID", decision.OrderID, "decision", decision.Decision, "approver", decision.Approver)
+
+	if err := a.orders.RecordApproval(ctx, decision.OrderID, decision.Decision, decision.Approver, decision.Comment); err != nil {
+		return fmt.Errorf("failed to record approval: %w", err)
+	}
 	return nil
 }
 
@@ -149,88 +798,235 @@ func (a *Activities) DeductPaymentActivity(ctx context.Context, request model.Or
 	logger := activity.GetLogger(ctx)
 	logger.Info("Processing payment", "orderID", inventoryResult.OrderID)
 
-	db, err := openDB("postgres", a.cfg.DBConnectionString())
+	order, err := a.orders.GetOrder(ctx, inventoryResult.OrderID)
 	if err != nil {
-		return PaymentResult{}, fmt.Errorf("failed to connect to database: %w", err)
+		return PaymentResult{}, fmt.Errorf("failed to fetch order total: %w", err)
+	}
+	// Do not charge an order that can no longer be paid, e.g. one already cancelled
+	if err := checkStatusTransition(order.Status, StatusShippingInitiated); err != nil {
+		return PaymentResult{}, err
 	}
-	defer db.Close()
 
-	// Update order status and fetch total price in a single round-trip
-	var totalPrice float64
-	err = db.QueryRow(
-		`UPDATE orders
-		 SET status = $1
-		 WHERE id = $2
-		 RETURNING total_price`,
-		"SHIPPING_INITIATED",
-		inventoryResult.OrderID,
-	).Scan(&totalPrice)
+	stopHeartbeat := startHeartbeat(ctx)
+	defer stopHeartbeat()
+	authorizationID, err := a.payments.Authorize(ctx, PaymentAuthorization{
+		OrderID: order.ID,
+		UserID:  request.UserID,
+		Amount:  order.TotalPrice,
+	})
+	if errors.Is(err, ErrPaymentDeclined) {
+		return PaymentResult{}, newPaymentDeclinedError(order.ID, order.TotalPrice, fmt.Errorf("failed to authorize payment: %w", err))
+	}
+	if err != nil {
+		return PaymentResult{}, wrapFault(err, "failed to authorize payment")
+	}
+	captureID, err := a.payments.Capture(ctx, authorizationID, order.TotalPrice)
+	if errors.Is(err, ErrPaymentDeclined) {
+		return PaymentResult{}, newPaymentDeclinedError(order.ID, order.TotalPrice, fmt.Errorf("failed to capture payment: %w", err))
+	}
 	if err != nil {
-		return PaymentResult{}, fmt.Errorf("failed to update order status or fetch total: %w", err)
+		return PaymentResult{}, fmt.Errorf("failed to capture payment: %w", err)
 	}
 
-	// Simulate payment processing
-	// In a real scenario, this would call a payment gateway
-	time.Sleep(2 * time.Second) // Simulate API call
+	// Persist the gateway transaction IDs so the payment can be traced and
+	// refunded. The money has been taken, so a cancellation arriving now must
+	// not stop the activity from returning the capture for the refund.
+	err = a.transitionOrder(context.WithoutCancel(ctx), order.ID, StatusShippingInitiated, "payment captured", func(ctx context.Context) error {
+		return a.orders.RecordPayment(ctx, order.ID, StatusShippingInitiated, authorizationID, captureID)
+	})
+	if err != nil {
+		return PaymentResult{}, wrapFault(err, "failed to update order payment")
+	}
 
-	logger.Info("Payment processed successfully", "amount", totalPrice)
+	logger.Info("Payment processed successfully", "amount", order.TotalPrice, "captureID", captureID)
 	return PaymentResult{
-		OrderID:    inventoryResult.OrderID,
-		AmountPaid: totalPrice,
+		OrderID:         order.ID,
+		AmountPaid:      order.TotalPrice,
+		AuthorizationID: authorizationID,
+		CaptureID:       captureID,
 	}, nil
 }
 
 // Compensation Activity: Refund Payment
-func (a *Activities) RefundPaymentActivity(ctx context.Context, result PaymentResult) error {
+func (a *Activities) RefundPaymentActivity(ctx context.Context, result PaymentResult) (CompensationResult, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Refunding payment", "orderID", result.OrderID, "amount", result.AmountPaid)
 
-	db, err := openDB("postgres", a.cfg.DBConnectionString())
+	order, err := a.orders.GetOrder(ctx, result.OrderID)
+	if err != nil {
+		return CompensationResult{}, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if order.PaymentRefundID != "" {
+		logger.Info("Payment already refunded", "refundID", order.PaymentRefundID)
+		return CompensationResult{OrderID: result.OrderID}, nil
+	}
+	// A payment cancelled in flight returns no result: refund the capture the
+	// order recorded or, when the cancellation came before it was recorded,
+	// the one the gateway holds for the order, if the payment got that far
+	var found *PaymentCapture
+	if result.CaptureID == "" && order.PaymentCaptureID != "" {
+		result.CaptureID, result.AmountPaid = order.PaymentCaptureID, order.TotalPrice
+	}
+	if result.CaptureID == "" {
+		capture, err := a.payments.FindCapture(ctx, result.OrderID)
+		if errors.Is(err, ErrCaptureNotFound) {
+			logger.Info("No payment captured, nothing to refund")
+			return CompensationResult{OrderID: result.OrderID}, nil
+		}
+		if err != nil {
+			return CompensationResult{}, fmt.Errorf("failed to look up payment: %w", err)
+		}
+		found = &capture
+		result.AuthorizationID, result.CaptureID, result.AmountPaid = capture.AuthorizationID, capture.CaptureID, capture.Amount
+	}
+	if err := checkStatusTransition(order.Status, StatusPaymentFailed); err != nil {
+		return CompensationResult{}, err
+	}
+
+	// The gateway returns the existing refund for a capture, so a retry after a
+	// failed update below does not refund twice
+	refundID, err := a.payments.Refund(ctx, result.CaptureID, result.AmountPaid)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		logger.Error("Failed to refund payment", "error", err)
+		return CompensationResult{}, fmt.Errorf("failed to refund payment: %w", err)
 	}
-	defer db.Close()
 
-	// Update order status to indicate payment failure
-	_, err = db.Exec(
-		`UPDATE orders SET status = $1 WHERE id = $2`,
-		"PAYMENT_FAILED",
-		result.OrderID,
-	)
+	// Update order status to indicate payment failure and record the refund,
+	// together with the capture when only the gateway knew of it
+	err = a.transitionOrder(ctx, result.OrderID, StatusPaymentFailed, "payment refunded", func(ctx context.Context) error {
+		if found != nil {
+			if err := a.orders.RecordPayment(ctx, result.OrderID, StatusPaymentFailed, found.AuthorizationID, found.CaptureID); err != nil {
+				return err
+			}
+		}
+		return a.orders.RecordRefund(ctx, result.OrderID, StatusPaymentFailed, refundID)
+	})
 	if err != nil {
 		logger.Error("Failed to update order status for refund", "error", err)
-		return fmt.Errorf("failed to update order status: %w", err)
+		return CompensationResult{}, wrapFault(err, "failed to update order status")
 	}
 
-	// In a real scenario, this would call a payment gateway to process the refund
-	logger.Info("Payment refunded successfully")
-	return nil
+	logger.Info("Payment refunded successfully", "refundID", refundID)
+	return CompensationResult{OrderID: result.OrderID, Applied: true}, nil
 }
 
 // Activity 3: Shipping
-func (a *Activities) ShippingActivity(ctx context.Context, request model.OrderRequest, paymentResult PaymentResult) error {
+func (a *Activities) ShippingActivity(ctx context.Context, request model.OrderRequest, paymentResult PaymentResult) (ShippingResult, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Processing shipping", "orderID", paymentResult.OrderID)
 
-	db, err := openDB("postgres", a.cfg.DBConnectionString())
+	order, err := a.orders.GetOrder(ctx, paymentResult.OrderID)
+	if err != nil {
+		return ShippingResult{}, fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if err := checkStatusTransition(order.Status, StatusShipped); err != nil {
+		return ShippingResult{}, err
+	}
+
+	user, err := a.users.GetUser(ctx, request.UserID)
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+		return ShippingResult{}, fmt.Errorf("failed to get shipping address for user %s: %w", request.UserID, err)
 	}
-	defer db.Close()
 
-	// Simulate shipping process
-	time.Sleep(2 * time.Second) // Simulate shipping API call
+	stopHeartbeat := startHeartbeat(ctx)
+	defer stopHeartbeat()
+	shipment, err := a.carrier.CreateShipment(ctx, ShipmentRequest{
+		OrderID: paymentResult.OrderID,
+		UserID:  request.UserID,
+		Address: user.Address,
+		Items:   request.Items,
+	})
+	if errors.Is(err, ErrShipmentRejected) {
+		return ShippingResult{}, newShipmentRejectedError(paymentResult.OrderID, fmt.Errorf("failed to create shipment: %w", err))
+	}
+	if err != nil {
+		return ShippingResult{}, fmt.Errorf("failed to create shipment: %w", err)
+	}
+
+	// Record the shipment on the order; delivery is reported by the carrier
+	// later. Once booked, the shipment is recorded and returned even if the
+	// activity is cancelled meanwhile, so that it can be cancelled in turn.
+	err = a.transitionOrder(context.WithoutCancel(ctx), paymentResult.OrderID, StatusShipped, "shipment booked", func(ctx context.Context) error {
+		return a.orders.RecordShipment(ctx, paymentResult.OrderID, StatusShipped, shipment)
+	})
+	if err != nil {
+		return ShippingResult{}, wrapFault(err, "failed to update order shipment")
+	}
+
+	logger.Info("Shipment created successfully", "trackingNumber", shipment.TrackingNumber)
+	return ShippingResult{
+		OrderID:        paymentResult.OrderID,
+		ShipmentID:     shipment.ShipmentID,
+		TrackingNumber: shipment.TrackingNumber,
+		LabelURL:       shipment.LabelURL,
+	}, nil
+}
+
+// Compensation Activity: Cancel Shipment
+func (a *Activities) CancelShipmentActivity(ctx context.Context, result ShippingResult) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Cancelling shipment", "orderID", result.OrderID, "shipmentID", result.ShipmentID)
 
-	// Update order status to delivered
-	_, err = db.Exec(
-		`UPDATE orders SET status = $1 WHERE id = $2`,
-		"ORDER_DELIVERED",
-		paymentResult.OrderID,
-	)
+	order, err := a.orders.GetOrder(ctx, result.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch order: %w", err)
+	}
+	if order.ShipmentCancelled {
+		logger.Info("Shipment already cancelled")
+		return nil
+	}
+	if err := checkStatusTransition(order.Status, StatusShipmentCancelled); err != nil {
+		return err
+	}
+
+	// The carrier accepts cancelling a cancelled shipment, so a retry after a
+	// failed update below succeeds
+	if err := a.carrier.CancelShipment(ctx, result.ShipmentID); err != nil {
+		logger.Error("Failed to cancel shipment", "error", err)
+		return fmt.Errorf("failed to cancel shipment: %w", err)
+	}
+
+	err = a.transitionOrder(ctx, result.OrderID, StatusShipmentCancelled, "shipment cancelled", func(ctx context.Context) error {
+		return a.orders.RecordShipmentCancellation(ctx, result.OrderID, StatusShipmentCancelled)
+	})
+	if err != nil {
+		logger.Error("Failed to update order status for shipment cancellation", "error", err)
+		return wrapFault(err, "failed to update order status")
+	}
+
+	logger.Info("Shipment cancelled successfully")
+	return nil
+}
+
+// MarkPaymentFailedActivity moves an order whose payment was declined to
+// PAYMENT_FAILED once it has been rolled back.
+func (a *Activities) MarkPaymentFailedActivity(ctx context.Context, orderID uuid.UUID) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Marking order payment failed", "orderID", orderID)
+
+	err := a.transitionOrder(ctx, orderID, StatusPaymentFailed, "payment declined", func(ctx context.Context) error {
+		return a.orders.UpdateOrderStatus(ctx, orderID, StatusPaymentFailed)
+	})
+	if err != nil {
+		return wrapFault(err, "failed to update order status")
+	}
+
+	logger.Info("Order marked payment failed successfully")
+	return nil
+}
+
+// Activity: Mark Order Cancelled
+func (a *Activities) MarkOrderCancelledActivity(ctx context.Context, orderID uuid.UUID) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Marking order cancelled", "orderID", orderID)
+
+	err := a.transitionOrder(ctx, orderID, StatusCancelled, "order cancelled", func(ctx context.Context) error {
+		return a.orders.UpdateOrderStatus(ctx, orderID, StatusCancelled)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update order status: %w", err)
+		return wrapFault(err, "failed to update order status")
 	}
 
-	logger.Info("Shipping completed successfully")
+	logger.Info("Order cancelled successfully")
 	return nil
 }
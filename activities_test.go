@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 	"sktemporal/model"
 )
@@ -24,488 +27,1514 @@ func TestActivitiesTestSuite(t *testing.T) {
 	})
 }
 
-const (
-	releaseInventoryQuery    = "UPDATE products SET items_available = items_available \\+ \\$1 WHERE id = \\$2"
-	selectProductQuery       = "SELECT items_available, price FROM products WHERE id = \\$1"
-	updateProductQuery       = "UPDATE products SET items_available = \\$1 WHERE id = \\$2"
-	insertOrderQuery         = "INSERT INTO orders \\(id, userID, products, total_price, status\\)"
-	deductPaymentUpdateQuery = "UPDATE orders.*SET status.*RETURNING total_price"
-	updateOrderStatusQuery   = "UPDATE orders SET status = \\$1 WHERE id = \\$2"
-)
+// newActivities returns Activities backed by a fresh MemoryStore.
+func (s *ActivitiesTestSuite) newActivities(opts ...ActivitiesOption) (*Activities, *MemoryStore) {
+	store := NewMemoryStore()
+	opts = append([]ActivitiesOption{WithRepositories(store.Repositories())}, opts...)
+	activities, err := NewActivities(&Config{}, opts...)
+	s.Require().NoError(err)
+	return activities, store
+}
+
+// addOrder stores a single-item order with the given status and returns it.
+// requireBusinessError asserts that err is a non-retryable application error of
+// errType and, unless want is nil, that its details equal want.
+func (s *ActivitiesTestSuite) requireBusinessError(err error, errType string, want interface{}) {
+	var appErr *temporal.ApplicationError
+	s.Require().ErrorAs(err, &appErr)
+	s.Require().Equal(errType, appErr.Type())
+	s.Require().True(appErr.NonRetryable())
+	if want == nil {
+		return
+	}
+	details := reflect.New(reflect.TypeOf(want))
+	s.Require().NoError(appErr.Details(details.Interface()))
+	s.Require().Equal(want, details.Elem().Interface())
+}
+
+func (s *ActivitiesTestSuite) addOrder(store *MemoryStore, totalPrice model.Money, status string) Order {
+	order := Order{
+		ID:         uuid.New(),
+		UserID:     uuid.New(),
+		Items:      []model.OrderItem{{ProductID: uuid.New(), Quantity: 1, UnitPrice: totalPrice}},
+		TotalPrice: totalPrice,
+		Status:     status,
+	}
+	s.Require().NoError(store.CreateOrder(context.Background(), order))
+	return order
+}
+
+func (s *ActivitiesTestSuite) getOrder(store *MemoryStore, id uuid.UUID) Order {
+	order, err := store.GetOrder(context.Background(), id)
+	s.Require().NoError(err)
+	return order
+}
+
+func (s *ActivitiesTestSuite) stock(store *MemoryStore, id uuid.UUID) int {
+	product, err := store.GetProductForUpdate(context.Background(), id)
+	s.Require().NoError(err)
+	return product.ItemsAvailable
+}
+
+// inr returns an amount of paise in INR.
+func inr(paise int64) model.Money {
+	return model.NewMoney(paise, "INR")
+}
+
+// capturedPayment returns a fake gateway holding a captured payment for amount and the matching PaymentResult.
+func (s *ActivitiesTestSuite) capturedPayment(amount model.Money) (*FakePaymentGateway, PaymentResult) {
+	gateway := NewFakePaymentGateway()
+	orderID := uuid.New()
+	authID, err := gateway.Authorize(context.Background(), PaymentAuthorization{OrderID: orderID, Amount: amount})
+	s.Require().NoError(err)
+	captureID, err := gateway.Capture(context.Background(), authID, amount)
+	s.Require().NoError(err)
+	return gateway, PaymentResult{OrderID: orderID, AmountPaid: amount, AuthorizationID: authID, CaptureID: captureID}
+}
 
-func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_OpenDBFailure_ReturnsConnectError() {
+func (s *ActivitiesTestSuite) TestNewActivities_OpenDBFailure_ReturnsConnectError() {
 	connectErr := errors.New("driver: bad connection")
 	oldOpen := openDB
 	openDB = func(_, _ string) (*sql.DB, error) { return nil, connectErr }
 	defer func() { openDB = oldOpen }()
 
-	activities := NewActivities(&Config{})
-	env := s.NewTestActivityEnvironment()
-	env.RegisterActivity(activities)
-
-	request := model.OrderRequest{
-		UserID:          uuid.New(),
-		ProductID:       uuid.New(),
-		ProductQuantity: 2,
-	}
-
-	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	_, err := NewActivities(&Config{})
 	s.Require().Error(err)
 	s.Require().Contains(err.Error(), "failed to connect to database")
 	s.Require().Contains(err.Error(), "driver: bad connection")
 }
 
-func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_BeginTxFailure_ReturnsError() {
+func (s *ActivitiesTestSuite) TestNewActivities_SharesOnePoolAcrossActivities() {
 	db, mock, err := sqlmock.New()
 	s.Require().NoError(err)
 	defer db.Close()
 
-	mock.ExpectBegin().WillReturnError(errors.New("tx begin failed"))
-
+	opened := 0
 	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
+	openDB = func(_, _ string) (*sql.DB, error) {
+		opened++
+		return db, nil
+	}
 	defer func() { openDB = oldOpen }()
 
-	activities := NewActivities(&Config{})
+	activities, err := NewActivities(&Config{DBMaxOpenConns: 7})
+	s.Require().NoError(err)
+	s.Require().Equal(7, db.Stats().MaxOpenConnections)
+
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
-
-	request := model.OrderRequest{
-		UserID:          uuid.New(),
-		ProductID:       uuid.New(),
-		ProductQuantity: 1,
+	for i := 0; i < 2; i++ {
+		orderID := uuid.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery(selectOrderForUpdateQuery).
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows(orderColumns).
+				AddRow(uuid.New(), []byte("[]"), 10.0, "INR", []byte("[]"), []byte("[]"), []byte("[]"), false, StatusAddedToCart, nil, nil, nil, nil, nil, nil, false, false, nil, nil, nil, nil, time.Now()))
+		mock.ExpectExec(updateOrderStatusQuery).
+			WithArgs(StatusCancelled, orderID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insertStatusHistoryQuery).
+			WithArgs(orderID, sql.NullString{String: StatusAddedToCart, Valid: true}, StatusCancelled, "order cancelled", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		_, err = env.ExecuteActivity(activities.MarkOrderCancelledActivity, orderID)
+		s.Require().NoError(err)
 	}
+	s.Require().Equal(1, opened)
 
-	_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
-	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to begin transaction")
+	mock.ExpectClose()
+	s.Require().NoError(activities.Close())
 	s.Require().NoError(mock.ExpectationsWereMet())
 }
 
-func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_SelectProductFailure_ReturnsError() {
-	db, mock, err := sqlmock.New()
+func (s *ActivitiesTestSuite) TestNewActivities_WithRepositories_DoesNotOpenDB() {
+	oldOpen := openDB
+	openDB = func(_, _ string) (*sql.DB, error) { return nil, errors.New("unexpected open") }
+	defer func() { openDB = oldOpen }()
+
+	activities, err := NewActivities(&Config{}, WithRepositories(NewMemoryStore().Repositories()))
 	s.Require().NoError(err)
-	defer db.Close()
+	s.Require().NoError(activities.Close())
+}
 
-	productID := uuid.New()
-	userID := uuid.New()
-	mock.ExpectBegin()
-	mock.ExpectQuery(selectProductQuery).
-		WithArgs(productID).
-		WillReturnError(errors.New("product not found"))
-	mock.ExpectRollback()
+func (s *ActivitiesTestSuite) TestValidateUserActivity() {
+	activities, store := s.newActivities()
+	valid := User{ID: uuid.New(), Address: "123 Main St"}
+	noAddress := User{ID: uuid.New(), Address: "  "}
+	blocked := User{ID: uuid.New(), Address: "123 Main St", Blocked: true}
+	for _, u := range []User{valid, noAddress, blocked} {
+		store.AddUser(u)
+	}
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	_, err := env.ExecuteActivity(activities.ValidateUserActivity, valid.ID)
+	s.Require().NoError(err)
+
+	for userID, reason := range map[uuid.UUID]string{
+		uuid.New():   InvalidUserNotFound,
+		noAddress.ID: InvalidUserNoAddress,
+		blocked.ID:   InvalidUserBlocked,
+	} {
+		_, err := env.ExecuteActivity(activities.ValidateUserActivity, userID)
+		s.requireBusinessError(err, InvalidUserErrorType, InvalidUser{UserID: userID, Reason: reason})
+	}
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_NoItems_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: uuid.New()}
+
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "order has no items")
+	s.requireBusinessError(err, InvalidOrderErrorType, nil)
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_InvalidQuantity_ReturnsError() {
+	activities, _ := s.newActivities()
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
 	request := model.OrderRequest{
-		UserID:          userID,
-		ProductID:       productID,
-		ProductQuantity: 1,
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: uuid.New(), Quantity: 0}},
 	}
 
-	_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to get product")
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.Require().Contains(err.Error(), "invalid quantity 0")
 }
 
-func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_InsufficientStock_ReturnsError() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_ProductNotFound_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
 	productID := uuid.New()
-	userID := uuid.New()
-	mock.ExpectBegin()
-	mock.ExpectQuery(selectProductQuery).
-		WithArgs(productID).
-		WillReturnRows(sqlmock.NewRows([]string{"items_available", "price"}).AddRow(1, 100.0))
-	mock.ExpectRollback()
+	request := model.OrderRequest{
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: productID, Quantity: 1}},
+	}
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to get product")
+	s.Require().Contains(err.Error(), "not found")
+	s.requireBusinessError(err, ProductNotFoundErrorType, ProductNotFound{ProductID: productID})
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_InsufficientStock_ReturnsError() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 1, Price: inr(10000)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
 	request := model.OrderRequest{
-		UserID:          userID,
-		ProductID:       productID,
-		ProductQuantity: 5,
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: productID, Quantity: 5}},
 	}
 
-	_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
 	s.Require().Contains(err.Error(), "insufficient stock")
 	s.Require().Contains(err.Error(), "available 1, requested 5")
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.requireBusinessError(err, InsufficientStockErrorType, InsufficientStock{ProductID: productID, Available: 1, Requested: 5})
+	s.Require().Equal(1, s.stock(store, productID))
 }
 
 func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_Success_ReturnsInventoryResult() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
-
+	activities, store := s.newActivities()
 	productID := uuid.New()
 	userID := uuid.New()
 	quantity := 2
-	currentStock := 10
-	price := 100.0
-	newStock := currentStock - quantity
-
-	mock.ExpectBegin()
-	mock.ExpectQuery(selectProductQuery).
-		WithArgs(productID).
-		WillReturnRows(sqlmock.NewRows([]string{"items_available", "price"}).AddRow(currentStock, price))
-	mock.ExpectExec(updateProductQuery).
-		WithArgs(newStock, productID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	productsJSON, _ := json.Marshal(map[string]interface{}{
-		"productID": productID.String(),
-		"quantity":  quantity,
-	})
-	mock.ExpectExec(insertOrderQuery).
-		WithArgs(sqlmock.AnyArg(), userID, productsJSON, price*float64(quantity), "ADDED_TO_CART").
-		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectCommit()
-
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
-
-	activities := NewActivities(&Config{})
+	price := inr(10000)
+	store.AddProduct(Product{ID: productID, Type: "ELECTRONICS", ItemsAvailable: 10, Price: price})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
 	request := model.OrderRequest{
-		UserID:          userID,
-		ProductID:       productID,
-		ProductQuantity: quantity,
+		UserID: userID,
+		Items:  []model.OrderItem{{ProductID: productID, Quantity: quantity}},
 	}
 
 	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().NoError(err)
-	s.Require().NoError(mock.ExpectationsWereMet())
 
 	var result InventoryResult
 	s.Require().NoError(encoded.Get(&result))
-	s.Require().Equal(productID, result.ProductID)
-	s.Require().Equal(quantity, result.QuantityDeducted)
+	s.Require().Len(result.Items, 1)
+	s.Require().Equal(productID, result.Items[0].ProductID)
+	s.Require().Equal(quantity, result.Items[0].Quantity)
+	s.Require().Equal(price, result.Items[0].UnitPrice)
+	s.Require().Equal("ELECTRONICS", result.Items[0].ProductType)
+	s.Require().Equal(price.Mul(int64(quantity)), result.TotalPrice)
 	s.Require().NotEqual(uuid.Nil, result.OrderID)
-}
 
-func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_OpenDBFailure_ReturnsConnectError() {
-	connectErr := errors.New("driver: bad connection")
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return nil, connectErr }
-	defer func() { openDB = oldOpen }()
+	s.Require().Equal(8, s.stock(store, productID))
+	movements := store.Movements()
+	s.Require().Len(movements, 2)
+	s.Require().Equal(MovementOrderReserved, movements[1].Reason)
+	s.Require().Equal(result.OrderID, movements[1].OrderID)
+	s.Require().Equal(productID, movements[1].ProductID)
+	s.Require().Equal(-quantity, movements[1].Delta)
+	s.Require().NotEmpty(movements[1].WorkflowID)
+
+	order := s.getOrder(store, result.OrderID)
+	s.Require().Equal(userID, order.UserID)
+	s.Require().Equal(result.Items, order.Items)
+	s.Require().Equal(price.Mul(int64(quantity)), order.TotalPrice)
+	s.Require().Equal("ADDED_TO_CART", order.Status)
+
+	history := store.StatusHistory(result.OrderID)
+	s.Require().Len(history, 1)
+	s.Require().Empty(history[0].FromStatus)
+	s.Require().Equal(StatusAddedToCart, history[0].ToStatus)
+	s.Require().NotEmpty(history[0].RunID)
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_MultipleItems_ReservesAllAndSumsPrice() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 5, Price: inr(100000)})
+	store.AddProduct(Product{ID: caseID, ItemsAvailable: 10, Price: inr(2550)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	result := InventoryResult{
-		ProductID:        uuid.New(),
-		QuantityDeducted: 1,
-		OrderID:          uuid.New(),
+	request := model.OrderRequest{
+		UserID: uuid.New(),
+		Items: []model.OrderItem{
+			{ProductID: phoneID, Quantity: 1},
+			{ProductID: caseID, Quantity: 3},
+		},
 	}
 
-	_, err := env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
-	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to connect to database")
-	s.Require().Contains(err.Error(), "driver: bad connection")
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+
+	var result InventoryResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Len(result.Items, 2)
+	s.Require().Equal(inr(107650), result.TotalPrice)
+	s.Require().Equal(4, s.stock(store, phoneID))
+	s.Require().Equal(7, s.stock(store, caseID))
 }
 
-func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_DBQueryFailure_ReturnsError() {
-	db, mock, err := sqlmock.New()
+// lockRecorder records the products locked through the InventoryRepository it wraps.
+type lockRecorder struct {
+	InventoryRepository
+	locked []uuid.UUID
+}
+
+func (r *lockRecorder) GetProductForUpdate(ctx context.Context, id uuid.UUID) (Product, error) {
+	r.locked = append(r.locked, id)
+	return r.InventoryRepository.GetProductForUpdate(ctx, id)
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_SameProductTwice_MergesLinesAndLocksInIDOrder() {
+	store := NewMemoryStore()
+	repos := store.Repositories()
+	recorder := &lockRecorder{InventoryRepository: repos.Inventory}
+	repos.Inventory = recorder
+	activities, err := NewActivities(&Config{}, WithRepositories(repos))
 	s.Require().NoError(err)
-	defer db.Close()
+	lowID := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	highID := uuid.MustParse("ffffffff-0000-0000-0000-000000000001")
+	store.AddProduct(Product{ID: lowID, ItemsAvailable: 10, Price: inr(2550)})
+	store.AddProduct(Product{ID: highID, ItemsAvailable: 5, Price: inr(100000)})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
-	productID := uuid.New()
-	orderID := uuid.New()
-	quantity := 3
-	mock.ExpectExec(releaseInventoryQuery).
-		WithArgs(quantity, productID).
-		WillReturnError(errors.New("connection reset by peer"))
+	request := model.OrderRequest{
+		UserID: uuid.New(),
+		Items: []model.OrderItem{
+			{ProductID: highID, Quantity: 1},
+			{ProductID: lowID, Quantity: 2},
+			{ProductID: highID, Quantity: 2},
+		},
+	}
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+
+	var result InventoryResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal([]uuid.UUID{lowID, highID}, recorder.locked)
+	s.Require().Equal([]model.OrderItem{
+		{ProductID: highID, Quantity: 3, UnitPrice: inr(100000)},
+		{ProductID: lowID, Quantity: 2, UnitPrice: inr(2550)},
+	}, result.Items)
+	s.Require().Equal(inr(305100), result.TotalPrice)
+	s.Require().Equal(2, s.stock(store, highID))
+	s.Require().Equal(8, s.stock(store, lowID))
+	// The two opening adjustments and one reservation per product
+	s.Require().Len(store.Movements(), 4)
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_SecondItemInsufficientStock_RollsBack() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 5, Price: inr(100000)})
+	store.AddProduct(Product{ID: caseID, ItemsAvailable: 2, Price: inr(2550)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	result := InventoryResult{
-		ProductID:        productID,
-		QuantityDeducted: quantity,
-		OrderID:          orderID,
+	request := model.OrderRequest{
+		UserID: uuid.New(),
+		Items: []model.OrderItem{
+			{ProductID: phoneID, Quantity: 1},
+			{ProductID: caseID, Quantity: 3},
+		},
 	}
 
-	_, err = env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to release inventory")
-	s.Require().Contains(err.Error(), "connection reset by peer")
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.Require().Contains(err.Error(), "insufficient stock for product "+caseID.String())
+	s.Require().Equal(5, s.stock(store, phoneID))
+	s.Require().Equal(2, s.stock(store, caseID))
+	// Only the two opening adjustments; the reservation of the phone was rolled back
+	s.Require().Len(store.Movements(), 2)
 }
 
-func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_Success_ReturnsNil() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
-
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_OtherCurrency_ConvertsAndSnapshotsRate() {
+	rates := &StaticExchangeRates{AsOf: "2026-10-01", Rates: map[string]map[string]string{"INR": {"USD": "0.012"}}}
+	activities, store := s.newActivities(WithExchangeRates(rates))
 	productID := uuid.New()
-	orderID := uuid.New()
-	quantity := 2
-	mock.ExpectExec(releaseInventoryQuery).
-		WithArgs(quantity, productID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
-
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
-
-	activities := NewActivities(&Config{})
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(99900)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	result := InventoryResult{
-		ProductID:        productID,
-		QuantityDeducted: quantity,
-		OrderID:          orderID,
+	request := model.OrderRequest{
+		UserID:   uuid.New(),
+		Items:    []model.OrderItem{{ProductID: productID, Quantity: 3}},
+		Currency: "USD",
 	}
 
-	_, err = env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().NoError(err)
-	s.Require().NoError(mock.ExpectationsWereMet())
-}
 
-// --- DeductPaymentActivity ---
+	var result InventoryResult
+	s.Require().NoError(encoded.Get(&result))
+	// 999.00 INR × 0.012 = 11.988 USD, rounded to 11.99 before multiplying by the quantity
+	s.Require().Equal(model.NewMoney(1199, "USD"), result.Items[0].UnitPrice)
+	s.Require().Equal(model.NewMoney(3597, "USD"), result.TotalPrice)
 
-func (s *ActivitiesTestSuite) TestDeductPaymentActivity_OpenDBFailure_ReturnsConnectError() {
-	connectErr := errors.New("driver: bad connection")
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return nil, connectErr }
-	defer func() { openDB = oldOpen }()
+	order := s.getOrder(store, result.OrderID)
+	s.Require().Equal(model.NewMoney(3597, "USD"), order.TotalPrice)
+	s.Require().Equal([]model.ExchangeRate{{From: "INR", To: "USD", Rate: "0.012", AsOf: "2026-10-01"}}, order.ExchangeRates)
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_NoExchangeRate_RollsBack() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	invResult := InventoryResult{ProductID: uuid.New(), QuantityDeducted: 1, OrderID: uuid.New()}
+	request := model.OrderRequest{
+		UserID:   uuid.New(),
+		Items:    []model.OrderItem{{ProductID: productID, Quantity: 2}},
+		Currency: "EUR",
+	}
 
-	_, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to connect to database")
+	s.Require().Contains(err.Error(), ErrNoExchangeRate.Error())
+	s.requireBusinessError(err, UnsupportedCurrencyErrorType, UnsupportedCurrency{From: "INR", To: "EUR"})
+	s.Require().Equal(10, s.stock(store, productID))
+	s.Require().Len(store.Movements(), 1)
 }
 
-func (s *ActivitiesTestSuite) TestDeductPaymentActivity_UpdateOrderFailure_ReturnsError() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
-
-	orderID := uuid.New()
-	mock.ExpectQuery(deductPaymentUpdateQuery).
-		WithArgs("SHIPPING_INITIATED", orderID).
-		WillReturnError(errors.New("order not found"))
-
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
-
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_InvalidCurrency_ReturnsError() {
+	activities, _ := s.newActivities()
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	invResult := InventoryResult{ProductID: uuid.New(), QuantityDeducted: 1, OrderID: orderID}
+	request := model.OrderRequest{
+		UserID:   uuid.New(),
+		Items:    []model.OrderItem{{ProductID: uuid.New(), Quantity: 1}},
+		Currency: "usd",
+	}
 
-	_, err = env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to update order status or fetch total")
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.Require().Contains(err.Error(), `invalid currency "usd"`)
+	s.requireBusinessError(err, InvalidOrderErrorType, nil)
 }
 
-func (s *ActivitiesTestSuite) TestDeductPaymentActivity_Success_ReturnsPaymentResult() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
-
-	orderID := uuid.New()
-	totalPrice := 199.99
-	mock.ExpectQuery(deductPaymentUpdateQuery).
-		WithArgs("SHIPPING_INITIATED", orderID).
-		WillReturnRows(sqlmock.NewRows([]string{"total_price"}).AddRow(totalPrice))
-
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
-
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_Coupons_DiscountTotalAndRecordRedemptions() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	userID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddCoupon(Coupon{Code: "SAVE10", Type: CouponPercentage, PercentOff: "10", Active: true})
+	store.AddCoupon(Coupon{Code: "FLAT50", Type: CouponFixedAmount, AmountOff: inr(5000), Active: true})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	invResult := InventoryResult{ProductID: uuid.New(), QuantityDeducted: 1, OrderID: orderID}
+	request := model.OrderRequest{
+		UserID:      userID,
+		Items:       []model.OrderItem{{ProductID: productID, Quantity: 3}},
+		CouponCodes: []string{"flat50", "save10"},
+	}
 
-	encoded, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().NoError(err)
-	s.Require().NoError(mock.ExpectationsWereMet())
 
-	var result PaymentResult
+	var result InventoryResult
 	s.Require().NoError(encoded.Get(&result))
-	s.Require().Equal(orderID, result.OrderID)
-	s.Require().Equal(totalPrice, result.AmountPaid)
+	// 300.00 - 10% - 50.00
+	s.Require().Equal(inr(22000), result.TotalPrice)
+	s.Require().Equal([]model.Discount{
+		{CouponCode: "SAVE10", Type: CouponPercentage, Amount: inr(3000)},
+		{CouponCode: "FLAT50", Type: CouponFixedAmount, Amount: inr(5000)},
+	}, result.Discounts)
+
+	order := s.getOrder(store, result.OrderID)
+	s.Require().Equal(inr(22000), order.TotalPrice)
+	s.Require().Equal(result.Discounts, order.Discounts)
+
+	redemptions := store.Redemptions()
+	s.Require().Len(redemptions, 2)
+	for _, r := range redemptions {
+		s.Require().Equal(userID, r.UserID)
+		s.Require().Equal(result.OrderID, r.OrderID)
+	}
 }
 
-// --- RefundPaymentActivity ---
-
-func (s *ActivitiesTestSuite) TestRefundPaymentActivity_OpenDBFailure_ReturnsConnectError() {
-	connectErr := errors.New("driver: bad connection")
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return nil, connectErr }
-	defer func() { openDB = oldOpen }()
-
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_CouponUsageLimit_RejectsAndRollsBack() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	userID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddCoupon(Coupon{Code: "WELCOME", Type: CouponPercentage, PercentOff: "20", MaxUsesPerUser: 1, Active: true})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	paymentResult := PaymentResult{OrderID: uuid.New(), AmountPaid: 99.99}
+	request := model.OrderRequest{
+		UserID:         userID,
+		Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		IdempotencyKey: "first",
+		CouponCodes:    []string{"WELCOME"},
+	}
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
 
-	_, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	request.IdempotencyKey = "second"
+	_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to connect to database")
+	s.Require().Contains(err.Error(), "coupon WELCOME already used 1 times")
+	s.requireBusinessError(err, InvalidCouponErrorType, InvalidCoupon{CouponCode: "WELCOME", Reason: InvalidCouponUsageLimit})
+	s.Require().Equal(9, s.stock(store, productID))
+	s.Require().Len(store.Redemptions(), 1)
 }
 
-func (s *ActivitiesTestSuite) TestRefundPaymentActivity_UpdateFailure_ReturnsError() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
-
-	orderID := uuid.New()
-	mock.ExpectExec(updateOrderStatusQuery).
-		WithArgs("PAYMENT_FAILED", orderID).
-		WillReturnError(errors.New("update failed"))
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_InactiveCoupon_ReturnsError() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddCoupon(Coupon{Code: "EXPIRED", Type: CouponPercentage, PercentOff: "10"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	for code, reason := range map[string]string{"EXPIRED": InvalidCouponInactive, "NOSUCH": InvalidCouponNotFound} {
+		request := model.OrderRequest{
+			UserID:      uuid.New(),
+			Items:       []model.OrderItem{{ProductID: productID, Quantity: 1}},
+			CouponCodes: []string{code},
+		}
+		_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+		s.Require().Error(err)
+		s.requireBusinessError(err, InvalidCouponErrorType, InvalidCoupon{CouponCode: code, Reason: reason})
+	}
+	s.Require().Equal(10, s.stock(store, productID))
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_Retry_ReturnsPreviousResultWithoutDeductingAgain() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	paymentResult := PaymentResult{OrderID: orderID, AmountPaid: 99.99}
+	request := model.OrderRequest{
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: productID, Quantity: 2}},
+	}
+
+	var first, second InventoryResult
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+	s.Require().NoError(encoded.Get(&first))
+	// Same workflow, so a retried attempt derives the same order ID
+	encoded, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+	s.Require().NoError(encoded.Get(&second))
 
-	_, err = env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
-	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to update order status")
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.Require().Equal(first, second)
+	s.Require().Equal(8, s.stock(store, productID))
 }
 
-func (s *ActivitiesTestSuite) TestRefundPaymentActivity_Success_ReturnsNil() {
-	db, mock, err := sqlmock.New()
-	s.Require().NoError(err)
-	defer db.Close()
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_IdempotencyKey_DerivesOrderID() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
 
-	orderID := uuid.New()
-	mock.ExpectExec(updateOrderStatusQuery).
-		WithArgs("PAYMENT_FAILED", orderID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	request := model.OrderRequest{
+		UserID:         uuid.New(),
+		Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		IdempotencyKey: "cart-42",
+	}
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	var results []InventoryResult
+	for i := 0; i < 2; i++ {
+		// A separate environment has the same workflow ID, so it stands in for
+		// a retry of the activity
+		env := s.NewTestActivityEnvironment()
+		env.RegisterActivity(activities)
+		encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+		s.Require().NoError(err)
+		var result InventoryResult
+		s.Require().NoError(encoded.Get(&result))
+		results = append(results, result)
+	}
 
-	activities := NewActivities(&Config{})
+	s.Require().Equal(results[0].OrderID, results[1].OrderID)
+	s.Require().Equal(9, s.stock(store, productID))
+
+	request.IdempotencyKey = "cart-43"
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
-
-	paymentResult := PaymentResult{OrderID: orderID, AmountPaid: 99.99}
-
-	_, err = env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
 	s.Require().NoError(err)
-	s.Require().NoError(mock.ExpectationsWereMet())
+	var other InventoryResult
+	s.Require().NoError(encoded.Get(&other))
+	s.Require().NotEqual(results[0].OrderID, other.OrderID)
+	s.Require().Equal(8, s.stock(store, productID))
 }
 
-// --- ShippingActivity ---
-
-func (s *ActivitiesTestSuite) TestShippingActivity_OpenDBFailure_ReturnsConnectError() {
-	connectErr := errors.New("driver: bad connection")
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return nil, connectErr }
-	defer func() { openDB = oldOpen }()
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_IdempotencyKeyOfAnotherUser_ReturnsError() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{
+		UserID:         uuid.New(),
+		Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		IdempotencyKey: "cart-42",
+	}
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+
+	request.UserID = uuid.New()
+	_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "already exists for another user")
+	s.Require().Equal(9, s.stock(store, productID))
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_IdempotencyKeyOfAnotherCart_ReturnsError() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	otherID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddProduct(Product{ID: otherID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddCoupon(Coupon{Code: "SAVE10", Type: CouponPercentage, PercentOff: "10", Active: true})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{
+		UserID:         uuid.New(),
+		Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		IdempotencyKey: "cart-42",
+	}
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+
+	tests := map[string]func(r *model.OrderRequest){
+		"other quantity": func(r *model.OrderRequest) { r.Items = []model.OrderItem{{ProductID: productID, Quantity: 2}} },
+		"other product":  func(r *model.OrderRequest) { r.Items = []model.OrderItem{{ProductID: otherID, Quantity: 1}} },
+		"extra item": func(r *model.OrderRequest) {
+			r.Items = append(r.Items, model.OrderItem{ProductID: otherID, Quantity: 1})
+		},
+		"other coupons":  func(r *model.OrderRequest) { r.CouponCodes = []string{"SAVE10"} },
+		"other currency": func(r *model.OrderRequest) { r.Currency = "USD" },
+	}
+	for name, change := range tests {
+		reused := request
+		change(&reused)
+		_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, reused)
+		s.Require().Error(err, name)
+		s.requireBusinessError(err, InvalidOrderErrorType, nil)
+		s.Require().Contains(err.Error(), "already exists with", name)
+	}
+	s.Require().Equal(9, s.stock(store, productID))
+	s.Require().Equal(10, s.stock(store, otherID))
+	s.Require().Empty(store.Redemptions())
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_IdempotencyKeyOfRolledBackOrder_ReturnsError() {
+	for _, rollBack := range []string{"released", "cancelled"} {
+		activities, store := s.newActivities()
+		productID := uuid.New()
+		store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+		env := s.NewTestActivityEnvironment()
+		env.RegisterActivity(activities)
+
+		request := model.OrderRequest{
+			UserID:         uuid.New(),
+			Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+			IdempotencyKey: "cart-42",
+		}
+		encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+		s.Require().NoError(err)
+		var result InventoryResult
+		s.Require().NoError(encoded.Get(&result))
+		if rollBack == "released" {
+			_, err = env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+		} else {
+			_, err = env.ExecuteActivity(activities.MarkOrderCancelledActivity, result.OrderID)
+		}
+		s.Require().NoError(err, rollBack)
+		stock := s.stock(store, productID)
+
+		_, err = env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+		s.Require().Error(err, rollBack)
+		s.requireBusinessError(err, InvalidOrderErrorType, nil)
+		s.Require().Contains(err.Error(), "already rolled back", rollBack)
+		s.Require().Equal(stock, s.stock(store, productID), rollBack)
+	}
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_IdempotencyKeyOfAnotherWorkflow_ReturnsDuplicateOrder() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 9, Price: inr(10000)})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{
+		UserID:         uuid.New(),
+		Items:          []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		IdempotencyKey: "cart-42",
+	}
+	shipped := Order{
+		ID:         newOrderID("", request),
+		UserID:     request.UserID,
+		Items:      []model.OrderItem{{ProductID: productID, Quantity: 1, UnitPrice: inr(10000)}},
+		TotalPrice: inr(10000),
+		Status:     StatusShipped,
+		WorkflowID: "order-1",
+	}
+	s.Require().NoError(store.CreateOrder(context.Background(), shipped))
+
+	_, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().Error(err)
+	s.requireBusinessError(err, DuplicateOrderErrorType, DuplicateOrder{OrderID: shipped.ID, WorkflowID: "order-1", Status: StatusShipped})
+	s.Require().Equal(9, s.stock(store, productID))
+	s.Require().Equal(StatusShipped, s.getOrder(store, shipped.ID).Status)
+}
+
+func (s *ActivitiesTestSuite) TestUpdateInventoryActivity_RecordsOwningWorkflow() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, model.OrderRequest{
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: productID, Quantity: 1}},
+	})
+	s.Require().NoError(err)
+	var result InventoryResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal("default-test-workflow-id", s.getOrder(store, result.OrderID).WorkflowID)
+}
+
+func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_ProductNotFound_RollsBack() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 4, Price: inr(100000)})
+	order := s.addOrder(store, inr(100000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	result := InventoryResult{
+		Items: []model.OrderItem{
+			{ProductID: phoneID, Quantity: 1},
+			{ProductID: uuid.New(), Quantity: 3},
+		},
+		OrderID: order.ID,
+	}
+
+	_, err := env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to release inventory")
+	s.Require().Equal(4, s.stock(store, phoneID))
+	s.Require().False(s.getOrder(store, order.ID).InventoryReleased)
+}
+
+func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_OrderNotFound_ReturnsError() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 4, Price: inr(100000)})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	result := InventoryResult{
+		Items:   []model.OrderItem{{ProductID: phoneID, Quantity: 1}},
+		OrderID: uuid.New(),
+	}
+
+	_, err := env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to mark inventory released")
+	s.Require().Equal(4, s.stock(store, phoneID))
+}
+
+func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_Success_RestoresEveryItem() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 4, Price: inr(100000)})
+	store.AddProduct(Product{ID: caseID, ItemsAvailable: 7, Price: inr(2550)})
+	order := s.addOrder(store, inr(107650), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	result := InventoryResult{
+		Items: []model.OrderItem{
+			{ProductID: phoneID, Quantity: 1},
+			{ProductID: caseID, Quantity: 3},
+		},
+		OrderID: order.ID,
+	}
+
+	encoded, err := env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	s.Require().NoError(err)
+	var released CompensationResult
+	s.Require().NoError(encoded.Get(&released))
+	s.Require().True(released.Applied)
+	s.Require().Equal(5, s.stock(store, phoneID))
+	s.Require().Equal(10, s.stock(store, caseID))
+	s.Require().True(s.getOrder(store, order.ID).InventoryReleased)
+
+	movements := store.Movements()[2:]
+	s.Require().Len(movements, 2)
+	for i, item := range result.Items {
+		s.Require().Equal(MovementOrderReleased, movements[i].Reason)
+		s.Require().Equal(order.ID, movements[i].OrderID)
+		s.Require().Equal(item.ProductID, movements[i].ProductID)
+		s.Require().Equal(item.Quantity, movements[i].Delta)
+	}
+}
+
+func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_ReleasesCoupons() {
+	activities, store := s.newActivities()
+	productID := uuid.New()
+	userID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 10, Price: inr(10000)})
+	store.AddCoupon(Coupon{Code: "WELCOME", Type: CouponPercentage, PercentOff: "20", MaxUsesPerUser: 1, Active: true})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{
+		UserID:      userID,
+		Items:       []model.OrderItem{{ProductID: productID, Quantity: 1}},
+		CouponCodes: []string{"WELCOME"},
+	}
+	encoded, err := env.ExecuteActivity(activities.UpdateInventoryActivity, request)
+	s.Require().NoError(err)
+	var result InventoryResult
+	s.Require().NoError(encoded.Get(&result))
+
+	_, err = env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+	s.Require().NoError(err)
+
+	redemptions := store.Redemptions()
+	s.Require().Len(redemptions, 1)
+	s.Require().False(redemptions[0].ReleasedAt.IsZero())
+	used, err := store.CountRedemptions(context.Background(), "WELCOME", userID)
+	s.Require().NoError(err)
+	s.Require().Zero(used)
+}
+
+func (s *ActivitiesTestSuite) TestReleaseInventoryActivity_Retry_ReleasesOnlyOnce() {
+	activities, store := s.newActivities()
+	phoneID := uuid.New()
+	store.AddProduct(Product{ID: phoneID, ItemsAvailable: 4, Price: inr(100000)})
+	order := s.addOrder(store, inr(100000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	result := InventoryResult{
+		Items:   []model.OrderItem{{ProductID: phoneID, Quantity: 1}},
+		OrderID: order.ID,
+	}
+
+	for i, wantApplied := range []bool{true, false, false} {
+		encoded, err := env.ExecuteActivity(activities.ReleaseInventoryActivity, result)
+		s.Require().NoError(err)
+		var released CompensationResult
+		s.Require().NoError(encoded.Get(&released))
+		s.Require().Equal(wantApplied, released.Applied, "attempt %d", i+1)
+	}
+	s.Require().Equal(5, s.stock(store, phoneID))
+	s.Require().Len(store.Movements(), 2)
+}
+
+// taxCalculatorFunc adapts a function to TaxCalculator.
+type taxCalculatorFunc func(ctx context.Context, req TaxRequest) ([]model.TaxLine, error)
+
+func (f taxCalculatorFunc) Calculate(ctx context.Context, req TaxRequest) ([]model.TaxLine, error) {
+	return f(ctx, req)
+}
+
+func (s *ActivitiesTestSuite) TestCalculateTaxActivity_Success_AddsTaxToTotal() {
+	activities, store := s.newActivities()
+	store.AddTaxRule(TaxRule{Name: "GST", Country: "India", Rate: "0.18"})
+	store.AddTaxRule(TaxRule{Name: "GST", Country: "India", ProductType: "CLOTHS", Rate: "0.05"})
+	order := Order{
+		ID:     uuid.New(),
+		UserID: uuid.New(),
+		Items: []model.OrderItem{
+			{ProductID: uuid.New(), Quantity: 1, UnitPrice: inr(100000), ProductType: "ELECTRONICS"},
+			{ProductID: uuid.New(), Quantity: 2, UnitPrice: inr(50000), ProductType: "CLOTHS"},
+		},
+		Discounts:  []model.Discount{{CouponCode: "FLAT200", Type: CouponFixedAmount, Amount: inr(20000)}},
+		TotalPrice: inr(180000),
+		Status:     StatusAddedToCart,
+	}
+	s.Require().NoError(store.CreateOrder(context.Background(), order))
+	store.AddUser(User{ID: order.UserID, Address: "12 MG Road, Bengaluru, India"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.CalculateTaxActivity, InventoryResult{OrderID: order.ID})
+	s.Require().NoError(err)
+
+	var result TaxResult
+	s.Require().NoError(encoded.Get(&result))
+	// The 200.00 discount is split 100.00 per line before tax
+	s.Require().Equal([]model.TaxLine{
+		{Name: "GST", Rate: "0.05", Taxable: inr(90000), Amount: inr(4500)},
+		{Name: "GST", Rate: "0.18", Taxable: inr(90000), Amount: inr(16200)},
+	}, result.Taxes)
+	s.Require().Equal(inr(20700), result.TaxTotal)
+	s.Require().Equal(inr(200700), result.TotalPrice)
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().True(stored.TaxCalculated)
+	s.Require().Equal(result.Taxes, stored.Taxes)
+	s.Require().Equal(inr(200700), stored.TotalPrice)
+
+	// A retry returns the recorded tax, even if the rules changed in between
+	store.AddTaxRule(TaxRule{Name: "CESS", Country: "India", Rate: "0.01"})
+	encoded, err = env.ExecuteActivity(activities.CalculateTaxActivity, InventoryResult{OrderID: order.ID})
+	s.Require().NoError(err)
+	var retried TaxResult
+	s.Require().NoError(encoded.Get(&retried))
+	s.Require().Equal(result, retried)
+	s.Require().Equal(inr(200700), s.getOrder(store, order.ID).TotalPrice)
+}
+
+func (s *ActivitiesTestSuite) TestCalculateTaxActivity_UserNotFound_ReturnsError() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(10000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.CalculateTaxActivity, InventoryResult{OrderID: order.ID})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to get tax address for user "+order.UserID.String())
+	s.Require().False(s.getOrder(store, order.ID).TaxCalculated)
+}
+
+func (s *ActivitiesTestSuite) TestCalculateTaxActivity_WithTaxCalculator_UsesIt() {
+	var got TaxRequest
+	calculator := taxCalculatorFunc(func(ctx context.Context, req TaxRequest) ([]model.TaxLine, error) {
+		got = req
+		return []model.TaxLine{{Name: "VAT", Rate: "0.2", Taxable: inr(10000), Amount: inr(2000)}}, nil
+	})
+	activities, store := s.newActivities(WithTaxCalculator(calculator))
+	order := s.addOrder(store, inr(10000), StatusAddedToCart)
+	store.AddUser(User{ID: order.UserID, Address: "1 High St, London, UK"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.CalculateTaxActivity, InventoryResult{OrderID: order.ID})
+	s.Require().NoError(err)
+	var result TaxResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal(inr(12000), result.TotalPrice)
+	s.Require().Equal("1 High St, London, UK", got.Address)
+	s.Require().Equal(order.ID, got.OrderID)
+	s.Require().Len(got.Lines, 1)
+}
+
+func (s *ActivitiesTestSuite) TestCalculateTaxActivity_CalculatorFails_ReturnsError() {
+	calculator := taxCalculatorFunc(func(ctx context.Context, req TaxRequest) ([]model.TaxLine, error) {
+		return nil, errors.New("tax service unavailable")
+	})
+	activities, store := s.newActivities(WithTaxCalculator(calculator))
+	order := s.addOrder(store, inr(10000), StatusAddedToCart)
+	store.AddUser(User{ID: order.UserID, Address: "1 High St, London, UK"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.CalculateTaxActivity, InventoryResult{OrderID: order.ID})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to calculate tax: tax service unavailable")
+	s.Require().Equal(inr(10000), s.getOrder(store, order.ID).TotalPrice)
+}
+
+// riskScorerFunc adapts a function to RiskScorer.
+type riskScorerFunc func(ctx context.Context, req RiskRequest) (RiskScore, error)
+
+func (f riskScorerFunc) Score(ctx context.Context, req RiskRequest) (RiskScore, error) {
+	return f(ctx, req)
+}
+
+func (s *ActivitiesTestSuite) TestAssessRiskActivity_DecidesFromScore() {
+	for score, want := range map[int]string{10: RiskApprove, 50: RiskReview, 95: RiskReject} {
+		var got RiskRequest
+		scorer := riskScorerFunc(func(ctx context.Context, req RiskRequest) (RiskScore, error) {
+			got = req
+			return RiskScore{Score: score, Reasons: []string{"test"}}, nil
+		})
+		store := NewMemoryStore()
+		cfg := &Config{RiskReviewScore: 40, RiskRejectScore: 90}
+		activities, err := NewActivities(cfg, WithRepositories(store.Repositories()), WithRiskScorer(scorer))
+		s.Require().NoError(err)
+		order := s.addOrder(store, inr(10000), StatusAddedToCart)
+		env := s.NewTestActivityEnvironment()
+		env.RegisterActivity(activities)
+
+		encoded, err := env.ExecuteActivity(activities.AssessRiskActivity, InventoryResult{OrderID: order.ID})
+		s.Require().NoError(err)
+		var result RiskResult
+		s.Require().NoError(encoded.Get(&result))
+		s.Require().Equal(RiskResult{OrderID: order.ID, Score: score, Reasons: []string{"test"}, Decision: want}, result)
+		s.Require().Equal(order.UserID, got.UserID)
+		s.Require().Equal(order.TotalPrice, got.TotalPrice)
+	}
+}
+
+func (s *ActivitiesTestSuite) TestAssessRiskActivity_DefaultScorerUsesConfigRules() {
+	store := NewMemoryStore()
+	cfg := &Config{RiskHighValue: inr(10000), RiskReviewScore: 40, RiskRejectScore: 90}
+	activities, err := NewActivities(cfg, WithRepositories(store.Repositories()))
+	s.Require().NoError(err)
+	order := s.addOrder(store, inr(10000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.AssessRiskActivity, InventoryResult{OrderID: order.ID})
+	s.Require().NoError(err)
+	var result RiskResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal(riskPointsHighValue, result.Score)
+	s.Require().Equal(RiskReview, result.Decision)
+}
+
+func (s *ActivitiesTestSuite) TestAssessRiskActivity_ReviewCarriesApprovalTimeout() {
+	store := NewMemoryStore()
+	cfg := &Config{RiskHighValue: inr(10000), RiskReviewScore: 40, ApprovalTimeout: time.Hour}
+	activities, err := NewActivities(cfg, WithRepositories(store.Repositories()))
+	s.Require().NoError(err)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	for total, want := range map[int64]time.Duration{100: 0, 10000: time.Hour} {
+		order := s.addOrder(store, inr(total), StatusAddedToCart)
+		encoded, err := env.ExecuteActivity(activities.AssessRiskActivity, InventoryResult{OrderID: order.ID})
+		s.Require().NoError(err)
+		var result RiskResult
+		s.Require().NoError(encoded.Get(&result))
+		s.Require().Equal(want, result.ApprovalTimeout, "total %d", total)
+	}
+}
+
+func (s *ActivitiesTestSuite) TestAssessRiskActivity_OrderNotFound_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.AssessRiskActivity, InventoryResult{OrderID: uuid.New()})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to fetch order")
+}
+
+func (s *ActivitiesTestSuite) TestRecordApprovalActivity_StoresApproverOnOrder() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(10000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.RecordApprovalActivity, ApprovalDecision{
+		OrderID:  order.ID,
+		Decision: ApprovalApproved,
+		Approver: "risk@example.com",
+		Comment:  "known customer",
+	})
+	s.Require().NoError(err)
+	got := s.getOrder(store, order.ID)
+	s.Require().Equal(ApprovalApproved, got.ApprovalDecision)
+	s.Require().Equal("risk@example.com", got.Approver)
+	s.Require().Equal("known customer", got.ApprovalComment)
+	s.Require().Equal(StatusAddedToCart, got.Status)
+}
+
+func (s *ActivitiesTestSuite) TestRecordApprovalActivity_OrderNotFound_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.RecordApprovalActivity, ApprovalDecision{OrderID: uuid.New(), Decision: ApprovalExpired})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to record approval")
+}
+
+func (s *ActivitiesTestSuite) TestDeductPaymentActivity_OrderNotFound_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: uuid.New(), Items: []model.OrderItem{{ProductID: uuid.New(), Quantity: 1}}}
+	invResult := InventoryResult{Items: request.Items, OrderID: uuid.New()}
+
+	_, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to fetch order total")
+}
+
+func (s *ActivitiesTestSuite) TestDeductPaymentActivity_Declined_ReturnsErrorWithoutUpdatingOrder() {
+	activities, store := s.newActivities(WithPaymentGateway(&FakePaymentGateway{DeclineAbove: inr(100000)}))
+	order := s.addOrder(store, inr(500000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	invResult := InventoryResult{Items: order.Items, OrderID: order.ID}
+
+	_, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to authorize payment")
+	s.Require().Contains(err.Error(), "payment declined")
+	s.requireBusinessError(err, PaymentDeclinedErrorType, PaymentDeclined{OrderID: order.ID, Amount: inr(500000)})
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal("ADDED_TO_CART", stored.Status)
+	s.Require().Empty(stored.PaymentCaptureID)
+}
+
+func (s *ActivitiesTestSuite) TestDeductPaymentActivity_DeclineLimitInAnotherCurrency_ReturnsUnsupportedCurrency() {
+	activities, store := s.newActivities(WithPaymentGateway(&FakePaymentGateway{DeclineAbove: model.NewMoney(100000, "USD")}))
+	order := s.addOrder(store, inr(500000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	invResult := InventoryResult{Items: order.Items, OrderID: order.ID}
+
+	_, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().Error(err)
+	s.requireBusinessError(err, UnsupportedCurrencyErrorType, UnsupportedCurrency{From: "INR", To: "USD"})
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal(StatusAddedToCart, stored.Status)
+	s.Require().Empty(stored.PaymentCaptureID)
+}
+
+func (s *ActivitiesTestSuite) TestDeductPaymentActivity_Success_ReturnsPaymentResult() {
+	activities, store := s.newActivities()
+	totalPrice := inr(19999)
+	order := s.addOrder(store, totalPrice, StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	invResult := InventoryResult{Items: order.Items, OrderID: order.ID}
+
+	encoded, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().NoError(err)
+
+	var result PaymentResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal(order.ID, result.OrderID)
+	s.Require().Equal(totalPrice, result.AmountPaid)
+	s.Require().Equal("auth-1", result.AuthorizationID)
+	s.Require().Equal("capture-auth-1", result.CaptureID)
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal("SHIPPING_INITIATED", stored.Status)
+	s.Require().Equal("auth-1", stored.PaymentAuthorizationID)
+	s.Require().Equal("capture-auth-1", stored.PaymentCaptureID)
+
+	history := store.StatusHistory(order.ID)
+	s.Require().Len(history, 1)
+	s.Require().Equal(StatusAddedToCart, history[0].FromStatus)
+	s.Require().Equal(StatusShippingInitiated, history[0].ToStatus)
+	s.Require().Equal("payment captured", history[0].Reason)
+
+	// A retry after the result was lost succeeds without a second history entry
+	_, err = env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().NoError(err)
+	s.Require().Len(store.StatusHistory(order.ID), 1)
+}
+
+func (s *ActivitiesTestSuite) TestDeductPaymentActivity_CancelledOrder_RejectedBeforeCharging() {
+	gateway := NewFakePaymentGateway()
+	activities, store := s.newActivities(WithPaymentGateway(gateway))
+	order := s.addOrder(store, inr(1000), StatusCancelled)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	invResult := InventoryResult{Items: order.Items, OrderID: order.ID}
+
+	_, err := env.ExecuteActivity(activities.DeductPaymentActivity, request, invResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "invalid order status transition")
+	s.requireBusinessError(err, InvalidStatusTransitionErrorType, InvalidStatusTransition{From: StatusCancelled, To: StatusShippingInitiated})
+	_, err = gateway.Capture(context.Background(), "auth-1", inr(1000))
+	s.Require().Error(err, "the gateway must not have authorized anything")
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_GatewayFailure_ReturnsError() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(9999), StatusShippingInitiated)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	paymentResult := PaymentResult{OrderID: order.ID, AmountPaid: inr(9999), CaptureID: "capture-unknown"}
+
+	_, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to refund payment")
+	s.Require().Equal(StatusShippingInitiated, s.getOrder(store, order.ID).Status)
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_OrderNotFound_ReturnsError() {
+	gateway, paymentResult := s.capturedPayment(inr(9999))
+	activities, _ := s.newActivities(WithPaymentGateway(gateway))
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to fetch order")
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_Success_RecordsRefund() {
+	gateway, paymentResult := s.capturedPayment(inr(9999))
+	activities, store := s.newActivities(WithPaymentGateway(gateway))
+	order := s.addOrder(store, inr(9999), StatusShippingInitiated)
+	paymentResult.OrderID = order.ID
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	s.Require().NoError(err)
+	var refunded CompensationResult
+	s.Require().NoError(encoded.Get(&refunded))
+	s.Require().True(refunded.Applied)
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal("PAYMENT_FAILED", stored.Status)
+	s.Require().NotEmpty(stored.PaymentRefundID)
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_WithoutCapture_RefundsRecordedCapture() {
+	gateway, captured := s.capturedPayment(inr(9999))
+	activities, store := s.newActivities(WithPaymentGateway(gateway))
+	order := s.addOrder(store, inr(9999), StatusAddedToCart)
+	s.Require().NoError(store.RecordPayment(context.Background(), order.ID, StatusShippingInitiated, captured.AuthorizationID, captured.CaptureID))
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	// The payment activity was cancelled after capturing, so only the order ID is known
+	encoded, err := env.ExecuteActivity(activities.RefundPaymentActivity, PaymentResult{OrderID: order.ID})
+	s.Require().NoError(err)
+	var refunded CompensationResult
+	s.Require().NoError(encoded.Get(&refunded))
+	s.Require().True(refunded.Applied)
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal(StatusPaymentFailed, stored.Status)
+	s.Require().NotEmpty(stored.PaymentRefundID)
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_CaptureOnlyAtGateway_RefundsAndRecordsIt() {
+	gateway, captured := s.capturedPayment(inr(9999))
+	activities, store := s.newActivities(WithPaymentGateway(gateway))
+	s.Require().NoError(store.CreateOrder(context.Background(), Order{
+		ID:         captured.OrderID,
+		UserID:     uuid.New(),
+		TotalPrice: inr(9999),
+		Status:     StatusAddedToCart,
+	}))
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	// The payment activity was cancelled after the gateway captured the payment
+	// but before the capture was written to the order
+	encoded, err := env.ExecuteActivity(activities.RefundPaymentActivity, PaymentResult{OrderID: captured.OrderID})
+	s.Require().NoError(err)
+	var refunded CompensationResult
+	s.Require().NoError(encoded.Get(&refunded))
+	s.Require().True(refunded.Applied)
+	stored := s.getOrder(store, captured.OrderID)
+	s.Require().Equal(StatusPaymentFailed, stored.Status)
+	s.Require().Equal(captured.AuthorizationID, stored.PaymentAuthorizationID)
+	s.Require().Equal(captured.CaptureID, stored.PaymentCaptureID)
+	s.Require().NotEmpty(stored.PaymentRefundID)
+	history := store.StatusHistory(captured.OrderID)
+	s.Require().Len(history, 1)
+	s.Require().Equal(StatusAddedToCart, history[0].FromStatus)
+}
+
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_NothingCaptured_DoesNothing() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(9999), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	encoded, err := env.ExecuteActivity(activities.RefundPaymentActivity, PaymentResult{OrderID: order.ID})
+	s.Require().NoError(err)
+	var refunded CompensationResult
+	s.Require().NoError(encoded.Get(&refunded))
+	s.Require().False(refunded.Applied)
+	s.Require().Equal(StatusAddedToCart, s.getOrder(store, order.ID).Status)
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_AlreadyRefunded_DoesNotCallGateway() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(9999), StatusShippingInitiated)
+	s.Require().NoError(store.RecordRefund(context.Background(), order.ID, "PAYMENT_FAILED", "refund-1"))
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	paymentResult := PaymentResult{OrderID: uuid.New(), AmountPaid: 199.99}
+	// The capture is unknown to the gateway, so reaching it would fail
+	paymentResult := PaymentResult{OrderID: order.ID, AmountPaid: inr(9999), CaptureID: "capture-unknown"}
+
+	encoded, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	s.Require().NoError(err)
+	var refunded CompensationResult
+	s.Require().NoError(encoded.Get(&refunded))
+	s.Require().False(refunded.Applied)
+	s.Require().Equal("refund-1", s.getOrder(store, order.ID).PaymentRefundID)
+}
+
+func (s *ActivitiesTestSuite) TestShippingActivity_UserNotFound_ReturnsError() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(19999), StatusShippingInitiated)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	paymentResult := PaymentResult{OrderID: order.ID, AmountPaid: inr(19999)}
 
 	_, err := env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
 	s.Require().Error(err)
-	s.Require().Contains(err.Error(), "failed to connect to database")
+	s.Require().Contains(err.Error(), "failed to get shipping address")
 }
 
-func (s *ActivitiesTestSuite) TestShippingActivity_UpdateFailure_ReturnsError() {
-	db, mock, err := sqlmock.New()
+func (s *ActivitiesTestSuite) TestShippingActivity_CarrierRejects_ReturnsError() {
+	activities, store := s.newActivities(WithShippingCarrier(&FakeShippingCarrier{RejectAll: true}))
+	order := s.addOrder(store, inr(19999), StatusShippingInitiated)
+	store.AddUser(User{ID: order.UserID, Address: "123 Main St"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	paymentResult := PaymentResult{OrderID: order.ID, AmountPaid: inr(19999)}
+
+	_, err := env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to create shipment")
+	s.requireBusinessError(err, ShipmentRejectedErrorType, ShipmentRejected{OrderID: order.ID})
+	s.Require().Empty(s.getOrder(store, order.ID).TrackingNumber)
+}
+
+func (s *ActivitiesTestSuite) TestShippingActivity_OrderNotFound_ReturnsError() {
+	activities, store := s.newActivities()
+	userID := uuid.New()
+	store.AddUser(User{ID: userID, Address: "123 Main St"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: userID, Items: []model.OrderItem{{ProductID: uuid.New(), Quantity: 1}}}
+	paymentResult := PaymentResult{OrderID: uuid.New(), AmountPaid: inr(19999)}
+
+	_, err := env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to fetch order")
+}
+
+func (s *ActivitiesTestSuite) TestShippingActivity_Success_ReturnsShippingResult() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(19999), StatusShippingInitiated)
+	store.AddUser(User{ID: order.UserID, Address: "123 Main St"})
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	request := model.OrderRequest{UserID: order.UserID, Items: order.Items}
+	paymentResult := PaymentResult{OrderID: order.ID, AmountPaid: inr(19999)}
+
+	encoded, err := env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
 	s.Require().NoError(err)
-	defer db.Close()
 
-	orderID := uuid.New()
-	mock.ExpectExec(updateOrderStatusQuery).
-		WithArgs("ORDER_DELIVERED", orderID).
-		WillReturnError(errors.New("update failed"))
+	var result ShippingResult
+	s.Require().NoError(encoded.Get(&result))
+	s.Require().Equal(order.ID, result.OrderID)
+	s.Require().Equal("shipment-1", result.ShipmentID)
+	s.Require().Equal("FAKE0000000001", result.TrackingNumber)
+	s.Require().NotEmpty(result.LabelURL)
+
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal("SHIPPED", stored.Status)
+	s.Require().Equal("shipment-1", stored.ShipmentID)
+	s.Require().Equal("FAKE0000000001", stored.TrackingNumber)
+	s.Require().Equal(result.LabelURL, stored.ShippingLabelURL)
+
+	history := store.StatusHistory(order.ID)
+	s.Require().Len(history, 1)
+	s.Require().Equal(StatusShippingInitiated, history[0].FromStatus)
+	s.Require().Equal(StatusShipped, history[0].ToStatus)
+}
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+func (s *ActivitiesTestSuite) TestCancelShipmentActivity_UnknownShipment_ReturnsError() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(1000), StatusShipped)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.CancelShipmentActivity, ShippingResult{OrderID: order.ID, ShipmentID: "shipment-missing"})
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "failed to cancel shipment")
+	s.Require().Equal(StatusShipped, s.getOrder(store, order.ID).Status)
+}
+
+func (s *ActivitiesTestSuite) TestCancelShipmentActivity_Success_CancelsWithCarrierAndRecordsIt() {
+	carrier := NewFakeShippingCarrier()
+	activities, store := s.newActivities(WithShippingCarrier(carrier))
+	order := s.addOrder(store, inr(1000), StatusShipped)
+	shipment, err := carrier.CreateShipment(context.Background(), ShipmentRequest{OrderID: order.ID})
+	s.Require().NoError(err)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	result := ShippingResult{OrderID: order.ID, ShipmentID: shipment.ShipmentID}
+	_, err = env.ExecuteActivity(activities.CancelShipmentActivity, result)
+	s.Require().NoError(err)
+	s.Require().True(carrier.Cancelled(shipment.ShipmentID))
+	stored := s.getOrder(store, order.ID)
+	s.Require().Equal(StatusShipmentCancelled, stored.Status)
+	s.Require().True(stored.ShipmentCancelled)
+	history := store.StatusHistory(order.ID)
+	s.Require().Len(history, 1)
+	s.Require().Equal(StatusShipped, history[0].FromStatus)
+	s.Require().Equal(StatusShipmentCancelled, history[0].ToStatus)
+	s.Require().Equal("shipment cancelled", history[0].Reason)
+
+	// A retry finds the cancellation recorded and changes nothing
+	_, err = env.ExecuteActivity(activities.CancelShipmentActivity, result)
+	s.Require().NoError(err)
+	s.Require().Len(store.StatusHistory(order.ID), 1)
+}
+
+func (s *ActivitiesTestSuite) TestCancelShipmentActivity_DeliveredOrder_RejectedBeforeCancelling() {
+	carrier := NewFakeShippingCarrier()
+	activities, store := s.newActivities(WithShippingCarrier(carrier))
+	order := s.addOrder(store, inr(1000), StatusOrderDelivered)
+	shipment, err := carrier.CreateShipment(context.Background(), ShipmentRequest{OrderID: order.ID})
+	s.Require().NoError(err)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err = env.ExecuteActivity(activities.CancelShipmentActivity, ShippingResult{OrderID: order.ID, ShipmentID: shipment.ShipmentID})
+	s.Require().Error(err)
+	s.requireBusinessError(err, InvalidStatusTransitionErrorType, InvalidStatusTransition{From: StatusOrderDelivered, To: StatusShipmentCancelled})
+	s.Require().False(carrier.Cancelled(shipment.ShipmentID))
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestMarkPaymentFailedActivity_RecordsStatusHistory() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(1000), StatusAddedToCart)
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	paymentResult := PaymentResult{OrderID: orderID, AmountPaid: 199.99}
+	_, err := env.ExecuteActivity(activities.MarkPaymentFailedActivity, order.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(StatusPaymentFailed, s.getOrder(store, order.ID).Status)
+	history := store.StatusHistory(order.ID)
+	s.Require().Len(history, 1)
+	s.Require().Equal(StatusAddedToCart, history[0].FromStatus)
+	s.Require().Equal(StatusPaymentFailed, history[0].ToStatus)
+	s.Require().Equal("payment declined", history[0].Reason)
+}
+
+func (s *ActivitiesTestSuite) TestMarkOrderCancelledActivity_OrderNotFound_ReturnsError() {
+	activities, _ := s.newActivities()
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
-	_, err = env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
+	_, err := env.ExecuteActivity(activities.MarkOrderCancelledActivity, uuid.New())
 	s.Require().Error(err)
 	s.Require().Contains(err.Error(), "failed to update order status")
-	s.Require().NoError(mock.ExpectationsWereMet())
 }
 
-func (s *ActivitiesTestSuite) TestShippingActivity_Success_ReturnsNil() {
-	db, mock, err := sqlmock.New()
+func (s *ActivitiesTestSuite) TestMarkOrderCancelledActivity_Success_ReturnsNil() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(1000), StatusAddedToCart)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity(activities.MarkOrderCancelledActivity, order.ID)
 	s.Require().NoError(err)
-	defer db.Close()
+	s.Require().Equal("CANCELLED", s.getOrder(store, order.ID).Status)
+	s.Require().Len(store.StatusHistory(order.ID), 1)
+}
 
-	orderID := uuid.New()
-	mock.ExpectExec(updateOrderStatusQuery).
-		WithArgs("ORDER_DELIVERED", orderID).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+func (s *ActivitiesTestSuite) TestMarkOrderCancelledActivity_DeliveredOrder_ReturnsError() {
+	activities, store := s.newActivities()
+	order := s.addOrder(store, inr(1000), StatusOrderDelivered)
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
 
-	oldOpen := openDB
-	openDB = func(_, _ string) (*sql.DB, error) { return db, nil }
-	defer func() { openDB = oldOpen }()
+	_, err := env.ExecuteActivity(activities.MarkOrderCancelledActivity, order.ID)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "invalid order status transition")
+	s.requireBusinessError(err, InvalidStatusTransitionErrorType, InvalidStatusTransition{From: StatusOrderDelivered, To: StatusCancelled})
+	s.Require().Equal(StatusOrderDelivered, s.getOrder(store, order.ID).Status)
+	s.Require().Empty(store.StatusHistory(order.ID))
+}
 
-	activities := NewActivities(&Config{})
+func (s *ActivitiesTestSuite) TestRefundPaymentActivity_DeliveredOrder_RejectedBeforeRefunding() {
+	gateway, paymentResult := s.capturedPayment(inr(9999))
+	activities, store := s.newActivities(WithPaymentGateway(gateway))
+	order := s.addOrder(store, inr(9999), StatusOrderDelivered)
+	paymentResult.OrderID = order.ID
 	env := s.NewTestActivityEnvironment()
 	env.RegisterActivity(activities)
 
-	request := model.OrderRequest{UserID: uuid.New(), ProductID: uuid.New(), ProductQuantity: 1}
-	paymentResult := PaymentResult{OrderID: orderID, AmountPaid: 199.99}
+	_, err := env.ExecuteActivity(activities.RefundPaymentActivity, paymentResult)
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), "invalid order status transition")
+	s.requireBusinessError(err, InvalidStatusTransitionErrorType, InvalidStatusTransition{From: StatusOrderDelivered, To: StatusPaymentFailed})
+	s.Require().Equal(StatusOrderDelivered, s.getOrder(store, order.ID).Status)
 
-	_, err = env.ExecuteActivity(activities.ShippingActivity, request, paymentResult)
+	// The capture was never refunded, so the gateway issues its first refund now
+	refundID, err := gateway.Refund(context.Background(), paymentResult.CaptureID, inr(9999))
 	s.Require().NoError(err)
-	s.Require().NoError(mock.ExpectationsWereMet())
+	s.Require().Equal("refund-2", refundID)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"time"
 
 	"sktemporal/model"
 
@@ -24,9 +25,13 @@ func main() {
 
 	// Create workflow request using UUIDs from seed data
 	request := model.OrderRequest{
-		UserID:          uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"), // from seed data
-		ProductID:       uuid.MustParse("660e8400-e29b-41d4-a716-446655440001"), // from seed data
-		ProductQuantity: 2,
+		UserID: uuid.MustParse("550e8400-e29b-41d4-a716-446655440000"), // from seed data
+		Items: []model.OrderItem{
+			{
+				ProductID: uuid.MustParse("660e8400-e29b-41d4-a716-446655440001"), // from seed data
+				Quantity:  2,
+			},
+		},
 	}
 
 	// Start workflow
@@ -44,12 +49,80 @@ func main() {
 
 	log.Printf("Started workflow with ID: %s and RunID: %s\n", we.GetID(), we.GetRunID())
 
+	// With the default risk rules the seed order, two ELECTRONICS items above
+	// RISK_HIGH_VALUE, scores at or above RISK_REVIEW_SCORE and waits for
+	// manual approval. Tell the operator how to decide; the client never
+	// approves orders itself. See "Manual Approval" in the README.
+	if err := reportPendingApproval(c, we.GetID()); err != nil {
+		log.Fatalln("Unable to check for pending approval", err)
+	}
+
 	// Wait for workflow completion (optional)
-	var result error
+	var result model.OrderResult
 	err = we.Get(context.Background(), &result)
 	if err != nil {
 		log.Fatalln("Unable to get workflow result", err)
 	}
 
-	log.Println("Workflow completed successfully")
+	// Business failures, e.g. InsufficientStock or PaymentDeclined, complete
+	// the workflow with the reason in the result
+	if result.FailureType != "" {
+		log.Fatalf("Order %s failed: %s: %s (status %s)\n", result.OrderID, result.FailureType, result.FailureMessage, result.Status)
+	}
+
+	log.Printf("Order %s completed: status %s, paid %s, tracking number %s\n",
+		result.OrderID, result.Status, result.AmountPaid, result.TrackingNumber)
+}
+
+// pendingApproval is the answer to the pending-approval query
+type pendingApproval struct {
+	OrderID     uuid.UUID
+	Score       int
+	Reasons     []string
+	RequestedAt time.Time
+	ExpiresAt   *time.Time
+}
+
+// reportPendingApproval follows the order until it is past its risk assessment
+// and, if the order is held for manual approval, prints the pending approval
+// with the commands an operator runs to approve or reject it.
+func reportPendingApproval(c client.Client, workflowID string) error {
+	for {
+		resp, err := c.QueryWorkflow(context.Background(), workflowID, "", "order-status")
+		if err != nil {
+			return err
+		}
+		var progress struct{ Step string }
+		if err := resp.Get(&progress); err != nil {
+			return err
+		}
+		switch progress.Step {
+		case "STARTED", "VALIDATING_USER", "UPDATING_INVENTORY", "CALCULATING_TAX", "ASSESSING_RISK":
+			time.Sleep(500 * time.Millisecond)
+			continue
+		case "AWAITING_APPROVAL":
+		default:
+			return nil
+		}
+
+		resp, err = c.QueryWorkflow(context.Background(), workflowID, "", "pending-approval")
+		if err != nil {
+			return err
+		}
+		var pending pendingApproval
+		if err := resp.Get(&pending); err != nil {
+			return err
+		}
+		log.Printf("Order %s is held for approval since %s with risk score %d: %v\n",
+			pending.OrderID, pending.RequestedAt.Format(time.RFC3339), pending.Score, pending.Reasons)
+		if pending.ExpiresAt != nil {
+			log.Printf("It is rejected at %s unless an operator decides first\n", pending.ExpiresAt.Format(time.RFC3339))
+		}
+		log.Printf("To approve it, run:\n"+
+			"  temporal workflow signal --workflow-id %[1]s --name approve --input '{\"approver\": \"<you>\"}'\n"+
+			"To reject it, run:\n"+
+			"  temporal workflow signal --workflow-id %[1]s --name reject --input '{\"approver\": \"<you>\", \"comment\": \"<reason>\"}'\n"+
+			"Waiting for the decision...\n", workflowID)
+		return nil
+	}
 }
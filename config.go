@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
+
+	"sktemporal/model"
 )
 
 const (
@@ -11,8 +15,28 @@ const (
 	pgHostDefault     = "temporal-postgres"
 	pgPortDefault     = "5432"
 	appDBNameDefault  = "appdb"
+
+	dbMaxOpenConnsDefault    = 20
+	dbMaxIdleConnsDefault    = 10
+	dbConnMaxLifetimeDefault = 30 * time.Minute
+
+	fakePaymentLatencyDefault  = 2 * time.Second
+	fakeShippingLatencyDefault = 2 * time.Second
+
+	riskVelocityLimitDefault  = 3
+	riskVelocityWindowDefault = time.Hour
+	riskNewAccountAgeDefault  = 7 * 24 * time.Hour
+	riskReviewScoreDefault    = 40
+	riskRejectScoreDefault    = 90
+
+	approvalTimeoutDefault = 24 * time.Hour
+
+	logLevelDefault = "info"
 )
 
+// riskHighValueDefault is the order total from which orders are scored as high value.
+var riskHighValueDefault = model.NewMoney(10000000, model.DefaultCurrency)
+
 // Config holds application configuration loaded from the environment.
 type Config struct {
 	PostgresUser     string
@@ -20,6 +44,42 @@ type Config struct {
 	PostgresHost     string
 	PostgresPort     string
 	AppDBName        string
+
+	// Connection pool limits for the app database
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+
+	// FakePaymentDeclineAbove makes the fake payment gateway decline orders above
+	// this total when positive, and fail orders in another currency;
+	// FakePaymentLatency delays every gateway call.
+	FakePaymentDeclineAbove model.Money
+	FakePaymentLatency      time.Duration
+
+	// FakeShippingLatency delays every call to the fake shipping carrier.
+	FakeShippingLatency time.Duration
+
+	// ExchangeRatesFile is a JSON file of exchange rates used to price orders in
+	// another currency than the products. Empty means no conversion is possible.
+	ExchangeRatesFile string
+
+	// Rules of the default risk scorer; a zero value disables the rule.
+	// Orders scoring RiskReviewScore or more wait for manual review and orders
+	// scoring RiskRejectScore or more are rejected; zero disables the decision.
+	RiskHighValue      model.Money
+	RiskVelocityLimit  int
+	RiskVelocityWindow time.Duration
+	RiskNewAccountAge  time.Duration
+	RiskReviewScore    int
+	RiskRejectScore    int
+
+	// ApprovalTimeout is how long an order held for manual approval waits for
+	// a decision before it is rejected; zero waits indefinitely.
+	ApprovalTimeout time.Duration
+
+	// LogLevel is the lowest level logged by the worker, workflows and
+	// activities: debug, info, warn or error.
+	LogLevel string
 }
 
 // DBConnectionString returns the PostgreSQL connection string for the app database.
@@ -48,6 +108,36 @@ func (c *Config) DBConnectionString() string {
 		pgUser, pgPassword, pgHost, pgPort, appDBName)
 }
 
+// DBPoolSettings returns the connection pool limits for the app database, using defaults for unset values.
+func (c *Config) DBPoolSettings() (maxOpen, maxIdle int, maxLifetime time.Duration) {
+	maxOpen = c.DBMaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = dbMaxOpenConnsDefault
+	}
+	maxIdle = c.DBMaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = dbMaxIdleConnsDefault
+	}
+	if maxIdle > maxOpen {
+		maxIdle = maxOpen
+	}
+	maxLifetime = c.DBConnMaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = dbConnMaxLifetimeDefault
+	}
+	return maxOpen, maxIdle, maxLifetime
+}
+
+// RiskRules returns the rules of the default risk scorer.
+func (c *Config) RiskRules() RiskRules {
+	return RiskRules{
+		HighValue:      c.RiskHighValue,
+		VelocityLimit:  c.RiskVelocityLimit,
+		VelocityWindow: c.RiskVelocityWindow,
+		NewAccountAge:  c.RiskNewAccountAge,
+	}
+}
+
 // LoadConfigFromEnv loads configuration from environment variables with defaults for development.
 func LoadConfigFromEnv() *Config {
 	return &Config{
@@ -56,6 +146,28 @@ func LoadConfigFromEnv() *Config {
 		PostgresHost:     getEnv("POSTGRES_HOST", pgHostDefault),
 		PostgresPort:     getEnv("POSTGRES_PORT", pgPortDefault),
 		AppDBName:        getEnv("APP_DB_NAME", appDBNameDefault),
+
+		DBMaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", dbMaxOpenConnsDefault),
+		DBMaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", dbMaxIdleConnsDefault),
+		DBConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", dbConnMaxLifetimeDefault),
+
+		FakePaymentDeclineAbove: getEnvMoney("FAKE_PAYMENT_DECLINE_ABOVE", model.Money{Currency: model.DefaultCurrency}),
+		FakePaymentLatency:      getEnvDuration("FAKE_PAYMENT_LATENCY", fakePaymentLatencyDefault),
+
+		FakeShippingLatency: getEnvDuration("FAKE_SHIPPING_LATENCY", fakeShippingLatencyDefault),
+
+		ExchangeRatesFile: getEnv("EXCHANGE_RATES_FILE", ""),
+
+		RiskHighValue:      getEnvMoney("RISK_HIGH_VALUE", riskHighValueDefault),
+		RiskVelocityLimit:  getEnvInt("RISK_VELOCITY_LIMIT", riskVelocityLimitDefault),
+		RiskVelocityWindow: getEnvDuration("RISK_VELOCITY_WINDOW", riskVelocityWindowDefault),
+		RiskNewAccountAge:  getEnvDuration("RISK_NEW_ACCOUNT_AGE", riskNewAccountAgeDefault),
+		RiskReviewScore:    getEnvInt("RISK_REVIEW_SCORE", riskReviewScoreDefault),
+		RiskRejectScore:    getEnvInt("RISK_REJECT_SCORE", riskRejectScoreDefault),
+
+		ApprovalTimeout: getEnvDuration("APPROVAL_TIMEOUT", approvalTimeoutDefault),
+
+		LogLevel: getEnv("LOG_LEVEL", logLevelDefault),
 	}
 }
 
@@ -65,3 +177,32 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// getEnvInt returns the env var parsed as an int, or defaultVal when unset or invalid.
+func getEnvInt(key string, defaultVal int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return defaultVal
+}
+
+// getEnvMoney returns the env var parsed as a decimal amount in the currency of
+// defaultVal (e.g. "5000.50"), or defaultVal when unset or invalid.
+func getEnvMoney(key string, defaultVal model.Money) model.Money {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultVal
+	}
+	if m, err := model.ParseMoney(v, defaultVal.Currency); err == nil {
+		return m
+	}
+	return defaultVal
+}
+
+// getEnvDuration returns the env var parsed as a time.Duration (e.g. "2s"), or defaultVal when unset or invalid.
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return defaultVal
+}
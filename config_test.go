@@ -3,6 +3,9 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
+
+	"sktemporal/model"
 )
 
 func TestConfig_DBConnectionString(t *testing.T) {
@@ -55,9 +58,55 @@ func TestConfig_DBConnectionString(t *testing.T) {
 	}
 }
 
+func TestConfig_DBPoolSettings(t *testing.T) {
+	tests := []struct {
+		name         string
+		c            *Config
+		wantOpen     int
+		wantIdle     int
+		wantLifetime time.Duration
+	}{
+		{
+			name:         "empty values use defaults",
+			c:            &Config{},
+			wantOpen:     20,
+			wantIdle:     10,
+			wantLifetime: 30 * time.Minute,
+		},
+		{
+			name:         "custom values",
+			c:            &Config{DBMaxOpenConns: 40, DBMaxIdleConns: 15, DBConnMaxLifetime: time.Hour},
+			wantOpen:     40,
+			wantIdle:     15,
+			wantLifetime: time.Hour,
+		},
+		{
+			name:         "idle connections capped at open connections",
+			c:            &Config{DBMaxOpenConns: 4, DBMaxIdleConns: 8},
+			wantOpen:     4,
+			wantIdle:     4,
+			wantLifetime: 30 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, idle, lifetime := tt.c.DBPoolSettings()
+			if open != tt.wantOpen || idle != tt.wantIdle || lifetime != tt.wantLifetime {
+				t.Errorf("DBPoolSettings() = (%d, %d, %v), want (%d, %d, %v)",
+					open, idle, lifetime, tt.wantOpen, tt.wantIdle, tt.wantLifetime)
+			}
+		})
+	}
+}
+
 func TestLoadConfigFromEnv_Defaults(t *testing.T) {
 	// Clear any relevant env vars so we get defaults
-	keys := []string{"POSTGRES_USER", "POSTGRES_PASSWORD", "POSTGRES_HOST", "POSTGRES_PORT", "APP_DB_NAME"}
+	keys := []string{"POSTGRES_USER", "POSTGRES_PASSWORD", "POSTGRES_HOST", "POSTGRES_PORT", "APP_DB_NAME",
+		"DB_MAX_OPEN_CONNS", "DB_MAX_IDLE_CONNS", "DB_CONN_MAX_LIFETIME",
+		"FAKE_PAYMENT_DECLINE_ABOVE", "FAKE_PAYMENT_LATENCY", "FAKE_SHIPPING_LATENCY",
+		"EXCHANGE_RATES_FILE", "RISK_HIGH_VALUE", "RISK_VELOCITY_LIMIT", "RISK_VELOCITY_WINDOW",
+		"RISK_NEW_ACCOUNT_AGE", "RISK_REVIEW_SCORE", "RISK_REJECT_SCORE", "APPROVAL_TIMEOUT",
+		"LOG_LEVEL"}
 	restore := clearEnv(keys)
 	defer restore()
 
@@ -78,6 +127,45 @@ func TestLoadConfigFromEnv_Defaults(t *testing.T) {
 	if got.AppDBName != "appdb" {
 		t.Errorf("AppDBName = %q, want appdb", got.AppDBName)
 	}
+	if got.DBMaxOpenConns != 20 {
+		t.Errorf("DBMaxOpenConns = %d, want 20", got.DBMaxOpenConns)
+	}
+	if got.DBMaxIdleConns != 10 {
+		t.Errorf("DBMaxIdleConns = %d, want 10", got.DBMaxIdleConns)
+	}
+	if got.DBConnMaxLifetime != 30*time.Minute {
+		t.Errorf("DBConnMaxLifetime = %v, want 30m", got.DBConnMaxLifetime)
+	}
+	if got.FakePaymentDeclineAbove != model.NewMoney(0, model.DefaultCurrency) {
+		t.Errorf("FakePaymentDeclineAbove = %v, want 0", got.FakePaymentDeclineAbove)
+	}
+	if got.FakePaymentLatency != 2*time.Second {
+		t.Errorf("FakePaymentLatency = %v, want 2s", got.FakePaymentLatency)
+	}
+	if got.FakeShippingLatency != 2*time.Second {
+		t.Errorf("FakeShippingLatency = %v, want 2s", got.FakeShippingLatency)
+	}
+	if got.ExchangeRatesFile != "" {
+		t.Errorf("ExchangeRatesFile = %q, want empty", got.ExchangeRatesFile)
+	}
+	wantRules := RiskRules{
+		HighValue:      model.NewMoney(10000000, model.DefaultCurrency),
+		VelocityLimit:  3,
+		VelocityWindow: time.Hour,
+		NewAccountAge:  7 * 24 * time.Hour,
+	}
+	if got.RiskRules() != wantRules {
+		t.Errorf("RiskRules() = %+v, want %+v", got.RiskRules(), wantRules)
+	}
+	if got.RiskReviewScore != 40 || got.RiskRejectScore != 90 {
+		t.Errorf("RiskReviewScore, RiskRejectScore = %d, %d, want 40, 90", got.RiskReviewScore, got.RiskRejectScore)
+	}
+	if got.ApprovalTimeout != 24*time.Hour {
+		t.Errorf("ApprovalTimeout = %v, want 24h", got.ApprovalTimeout)
+	}
+	if got.LogLevel != "info" {
+		t.Errorf("LogLevel = %q, want info", got.LogLevel)
+	}
 }
 
 func TestLoadConfigFromEnv_Overrides(t *testing.T) {
@@ -87,6 +175,25 @@ func TestLoadConfigFromEnv_Overrides(t *testing.T) {
 		"POSTGRES_HOST":     "db.example.com",
 		"POSTGRES_PORT":     "5434",
 		"APP_DB_NAME":       "testdb",
+
+		"DB_MAX_OPEN_CONNS":    "50",
+		"DB_MAX_IDLE_CONNS":    "25",
+		"DB_CONN_MAX_LIFETIME": "5m",
+
+		"FAKE_PAYMENT_DECLINE_ABOVE": "5000.50",
+		"FAKE_PAYMENT_LATENCY":       "150ms",
+		"FAKE_SHIPPING_LATENCY":      "1m",
+		"EXCHANGE_RATES_FILE":        "/etc/rates.json",
+
+		"RISK_HIGH_VALUE":      "50000",
+		"RISK_VELOCITY_LIMIT":  "10",
+		"RISK_VELOCITY_WINDOW": "24h",
+		"RISK_NEW_ACCOUNT_AGE": "0s",
+		"RISK_REVIEW_SCORE":    "30",
+		"RISK_REJECT_SCORE":    "0",
+		"APPROVAL_TIMEOUT":     "30m",
+
+		"LOG_LEVEL": "debug",
 	})
 	defer restore()
 
@@ -107,6 +214,64 @@ func TestLoadConfigFromEnv_Overrides(t *testing.T) {
 	if got.AppDBName != "testdb" {
 		t.Errorf("AppDBName = %q, want testdb", got.AppDBName)
 	}
+	if got.DBMaxOpenConns != 50 {
+		t.Errorf("DBMaxOpenConns = %d, want 50", got.DBMaxOpenConns)
+	}
+	if got.DBMaxIdleConns != 25 {
+		t.Errorf("DBMaxIdleConns = %d, want 25", got.DBMaxIdleConns)
+	}
+	if got.DBConnMaxLifetime != 5*time.Minute {
+		t.Errorf("DBConnMaxLifetime = %v, want 5m", got.DBConnMaxLifetime)
+	}
+	if got.FakePaymentDeclineAbove != model.NewMoney(500050, model.DefaultCurrency) {
+		t.Errorf("FakePaymentDeclineAbove = %v, want 5000.50", got.FakePaymentDeclineAbove)
+	}
+	if got.FakePaymentLatency != 150*time.Millisecond {
+		t.Errorf("FakePaymentLatency = %v, want 150ms", got.FakePaymentLatency)
+	}
+	if got.FakeShippingLatency != time.Minute {
+		t.Errorf("FakeShippingLatency = %v, want 1m", got.FakeShippingLatency)
+	}
+	if got.ExchangeRatesFile != "/etc/rates.json" {
+		t.Errorf("ExchangeRatesFile = %q, want /etc/rates.json", got.ExchangeRatesFile)
+	}
+	wantRules := RiskRules{
+		HighValue:      model.NewMoney(5000000, model.DefaultCurrency),
+		VelocityLimit:  10,
+		VelocityWindow: 24 * time.Hour,
+	}
+	if got.RiskRules() != wantRules {
+		t.Errorf("RiskRules() = %+v, want %+v", got.RiskRules(), wantRules)
+	}
+	if got.RiskReviewScore != 30 || got.RiskRejectScore != 0 {
+		t.Errorf("RiskReviewScore, RiskRejectScore = %d, %d, want 30, 0", got.RiskReviewScore, got.RiskRejectScore)
+	}
+	if got.ApprovalTimeout != 30*time.Minute {
+		t.Errorf("ApprovalTimeout = %v, want 30m", got.ApprovalTimeout)
+	}
+	if got.LogLevel != "debug" {
+		t.Errorf("LogLevel = %q, want debug", got.LogLevel)
+	}
+}
+
+func TestLoadConfigFromEnv_InvalidNumbersUseDefault(t *testing.T) {
+	restore := setEnv(map[string]string{
+		"DB_MAX_OPEN_CONNS":          "many",
+		"FAKE_PAYMENT_DECLINE_ABOVE": "lots",
+		"FAKE_PAYMENT_LATENCY":       "soon",
+	})
+	defer restore()
+
+	got := LoadConfigFromEnv()
+	if got.DBMaxOpenConns != 20 {
+		t.Errorf("DBMaxOpenConns with invalid env = %d, want default 20", got.DBMaxOpenConns)
+	}
+	if got.FakePaymentDeclineAbove != model.NewMoney(0, model.DefaultCurrency) {
+		t.Errorf("FakePaymentDeclineAbove with invalid env = %v, want default 0", got.FakePaymentDeclineAbove)
+	}
+	if got.FakePaymentLatency != 2*time.Second {
+		t.Errorf("FakePaymentLatency with invalid env = %v, want default 2s", got.FakePaymentLatency)
+	}
 }
 
 func TestLoadConfigFromEnv_EmptyEnvUsesDefault(t *testing.T) {
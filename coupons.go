@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"sktemporal/model"
+)
+
+// couponOrder is the order coupon types are applied in: free items come off
+// the lines first, percentages apply to what is left, and fixed amounts last so
+// they are never scaled down by a percentage.
+var couponOrder = map[string]int{
+	CouponBuyXGetY:    0,
+	CouponPercentage:  1,
+	CouponFixedAmount: 2,
+}
+
+// normalizeCouponCodes upper-cases the codes and rejects empty and repeated ones.
+func normalizeCouponCodes(codes []string) ([]string, error) {
+	normalized := make([]string, 0, len(codes))
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			return nil, fmt.Errorf("empty coupon code")
+		}
+		if seen[code] {
+			return nil, fmt.Errorf("coupon %s applied more than once", code)
+		}
+		seen[code] = true
+		normalized = append(normalized, code)
+	}
+	return normalized, nil
+}
+
+// computeDiscounts prices the coupons against the order lines and subtotal and
+// returns the discounts with the discounted total. Fixed amounts must already
+// be in the order currency. Each discount is capped
+// at what is left of the total, so the total never goes below zero. A coupon
+// that takes nothing off (e.g. buy 2 get 1 on a line of 2) is rejected, so that
+// the customer is not told a code was applied when it was not.
+func computeDiscounts(items []model.OrderItem, subtotal model.Money, coupons []Coupon) ([]model.Discount, model.Money, error) {
+	sorted := append([]Coupon(nil), coupons...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return couponOrder[sorted[i].Type] < couponOrder[sorted[j].Type]
+	})
+
+	remaining := subtotal
+	var discounts []model.Discount
+	for _, coupon := range sorted {
+		amount, err := couponAmount(coupon, items, remaining)
+		if err != nil {
+			return nil, model.Money{}, err
+		}
+		cmp, err := amount.Cmp(remaining)
+		if err != nil {
+			return nil, model.Money{}, fmt.Errorf("coupon %s: %w", coupon.Code, err)
+		}
+		if cmp > 0 {
+			amount = remaining
+		}
+		if amount.IsZero() || amount.IsNegative() {
+			return nil, model.Money{}, newInvalidCouponError(coupon.Code, InvalidCouponNotApplicable, "does not apply to this order", nil)
+		}
+		if remaining, err = remaining.Sub(amount); err != nil {
+			return nil, model.Money{}, err
+		}
+		discounts = append(discounts, model.Discount{CouponCode: coupon.Code, Type: coupon.Type, Amount: amount})
+	}
+	return discounts, remaining, nil
+}
+
+// couponAmount returns the amount the coupon takes off, before capping.
+func couponAmount(coupon Coupon, items []model.OrderItem, remaining model.Money) (model.Money, error) {
+	switch coupon.Type {
+	case CouponPercentage:
+		percent, ok := new(big.Rat).SetString(coupon.PercentOff)
+		if !ok || percent.Sign() <= 0 || percent.Cmp(big.NewRat(100, 1)) > 0 {
+			return model.Money{}, fmt.Errorf("coupon %s: invalid percentage %q", coupon.Code, coupon.PercentOff)
+		}
+		return remaining.MulRat(percent.Quo(percent, big.NewRat(100, 1))), nil
+	case CouponFixedAmount:
+		return coupon.AmountOff, nil
+	case CouponBuyXGetY:
+		if coupon.BuyQuantity <= 0 || coupon.FreeQuantity <= 0 {
+			return model.Money{}, fmt.Errorf("coupon %s: invalid buy %d get %d", coupon.Code, coupon.BuyQuantity, coupon.FreeQuantity)
+		}
+		amount := model.NewMoney(0, remaining.Currency)
+		for _, item := range items {
+			if item.ProductID != coupon.ProductID {
+				continue
+			}
+			// Every full group of buy + free units gets the free units for nothing
+			free := item.Quantity / (coupon.BuyQuantity + coupon.FreeQuantity) * coupon.FreeQuantity
+			var err error
+			if amount, err = amount.Add(item.UnitPrice.Mul(int64(free))); err != nil {
+				return model.Money{}, err
+			}
+		}
+		return amount, nil
+	}
+	return model.Money{}, fmt.Errorf("coupon %s: unknown type %q", coupon.Code, coupon.Type)
+}
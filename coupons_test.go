@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestNormalizeCouponCodes(t *testing.T) {
+	got, err := normalizeCouponCodes([]string{" save10 ", "Bogo"})
+	if err != nil {
+		t.Fatalf("normalizeCouponCodes() error = %v", err)
+	}
+	if want := []string{"SAVE10", "BOGO"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeCouponCodes() = %v, want %v", got, want)
+	}
+	for _, codes := range [][]string{{""}, {"save10", "SAVE10"}} {
+		if _, err := normalizeCouponCodes(codes); err == nil {
+			t.Errorf("normalizeCouponCodes(%q) error = nil, want error", codes)
+		}
+	}
+}
+
+func TestComputeDiscounts(t *testing.T) {
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	// 2 phones at 1000.00 and 3 cases at 25.50: 2076.50 INR
+	items := []model.OrderItem{
+		{ProductID: phoneID, Quantity: 2, UnitPrice: inr(100000)},
+		{ProductID: caseID, Quantity: 3, UnitPrice: inr(2550)},
+	}
+	subtotal := inr(207650)
+
+	percent := Coupon{Code: "SAVE10", Type: CouponPercentage, PercentOff: "10"}
+	fixed := Coupon{Code: "FLAT500", Type: CouponFixedAmount, AmountOff: inr(50000)}
+	bogo := Coupon{Code: "CASE3FOR2", Type: CouponBuyXGetY, ProductID: caseID, BuyQuantity: 2, FreeQuantity: 1}
+
+	tests := []struct {
+		name      string
+		coupons   []Coupon
+		want      []model.Discount
+		wantTotal model.Money
+		wantErr   string
+	}{
+		{
+			name:      "no coupons",
+			wantTotal: subtotal,
+		},
+		{
+			name:      "percentage rounds half away from zero",
+			coupons:   []Coupon{{Code: "SAVE12", Type: CouponPercentage, PercentOff: "12.5"}},
+			want:      []model.Discount{{CouponCode: "SAVE12", Type: CouponPercentage, Amount: inr(25956)}},
+			wantTotal: inr(181694),
+		},
+		{
+			name:      "buy x get y frees every full group",
+			coupons:   []Coupon{bogo},
+			want:      []model.Discount{{CouponCode: "CASE3FOR2", Type: CouponBuyXGetY, Amount: inr(2550)}},
+			wantTotal: inr(205100),
+		},
+		{
+			name:    "applied in type order whatever the request order",
+			coupons: []Coupon{fixed, percent, bogo},
+			want: []model.Discount{
+				{CouponCode: "CASE3FOR2", Type: CouponBuyXGetY, Amount: inr(2550)},
+				{CouponCode: "SAVE10", Type: CouponPercentage, Amount: inr(20510)},
+				{CouponCode: "FLAT500", Type: CouponFixedAmount, Amount: inr(50000)},
+			},
+			wantTotal: inr(134590),
+		},
+		{
+			name:      "fixed amount capped at the total",
+			coupons:   []Coupon{{Code: "BIG", Type: CouponFixedAmount, AmountOff: inr(500000)}},
+			want:      []model.Discount{{CouponCode: "BIG", Type: CouponFixedAmount, Amount: subtotal}},
+			wantTotal: inr(0),
+		},
+		{
+			name:    "buy x get y on a product not ordered",
+			coupons: []Coupon{{Code: "OTHER", Type: CouponBuyXGetY, ProductID: uuid.New(), BuyQuantity: 1, FreeQuantity: 1}},
+			wantErr: "does not apply",
+		},
+		{
+			name:    "buy x get y below the group size",
+			coupons: []Coupon{{Code: "PHONE3FOR2", Type: CouponBuyXGetY, ProductID: phoneID, BuyQuantity: 2, FreeQuantity: 1}},
+			wantErr: "does not apply",
+		},
+		{
+			name:    "nothing left to discount",
+			coupons: []Coupon{{Code: "BIG", Type: CouponFixedAmount, AmountOff: inr(500000)}, fixed},
+			wantErr: "FLAT500 does not apply",
+		},
+		{
+			name:    "fixed amount in another currency",
+			coupons: []Coupon{{Code: "USD5", Type: CouponFixedAmount, AmountOff: model.NewMoney(500, "USD")}},
+			wantErr: model.ErrCurrencyMismatch.Error(),
+		},
+		{
+			name:    "invalid percentage",
+			coupons: []Coupon{{Code: "TOOMUCH", Type: CouponPercentage, PercentOff: "150"}},
+			wantErr: "invalid percentage",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := computeDiscounts(items, subtotal, tt.coupons)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("computeDiscounts() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("computeDiscounts() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeDiscounts() = %+v, want %+v", got, tt.want)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("computeDiscounts() total = %v, want %v", total, tt.wantTotal)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Types of the non-retryable application errors an order fails with when it
+// cannot go through for a business reason. Running the activity again cannot
+// succeed, unlike database or network faults, which are returned as plain
+// errors and retried. Each type's comment names the details it carries.
+const (
+	// InvalidOrderErrorType has no details
+	InvalidOrderErrorType = "InvalidOrder"
+	// InvalidUserErrorType carries an InvalidUser
+	InvalidUserErrorType = "InvalidUser"
+	// ProductNotFoundErrorType carries a ProductNotFound
+	ProductNotFoundErrorType = "ProductNotFound"
+	// InsufficientStockErrorType carries an InsufficientStock
+	InsufficientStockErrorType = "InsufficientStock"
+	// InvalidCouponErrorType carries an InvalidCoupon
+	InvalidCouponErrorType = "InvalidCoupon"
+	// UnsupportedCurrencyErrorType carries an UnsupportedCurrency
+	UnsupportedCurrencyErrorType = "UnsupportedCurrency"
+	// PaymentDeclinedErrorType carries a PaymentDeclined
+	PaymentDeclinedErrorType = "PaymentDeclined"
+	// ShipmentRejectedErrorType carries a ShipmentRejected
+	ShipmentRejectedErrorType = "ShipmentRejected"
+	// OrderRejectedErrorType carries the RiskResult of a rejected risk
+	// assessment, or the ApprovalDecision of a rejected or expired approval
+	OrderRejectedErrorType = "OrderRejected"
+	// OrderCancelledErrorType carries the model.CancelOrderRequest of the cancel signal
+	OrderCancelledErrorType = "OrderCancelled"
+	// InvalidStatusTransitionErrorType carries an InvalidStatusTransition. It is
+	// returned when the order's status no longer allows the step, e.g. paying
+	// for an order already cancelled.
+	InvalidStatusTransitionErrorType = "InvalidStatusTransition"
+	// DuplicateOrderErrorType carries a DuplicateOrder. It is returned when the
+	// request's idempotency key belongs to an order another workflow created.
+	DuplicateOrderErrorType = "DuplicateOrder"
+)
+
+// businessErrorTypes are the error types of orders that cannot go through for
+// a business reason. OrderWorkflow reports them in its result instead of failing.
+var businessErrorTypes = map[string]bool{
+	InvalidOrderErrorType:            true,
+	InvalidUserErrorType:             true,
+	ProductNotFoundErrorType:         true,
+	InsufficientStockErrorType:       true,
+	InvalidCouponErrorType:           true,
+	UnsupportedCurrencyErrorType:     true,
+	PaymentDeclinedErrorType:         true,
+	ShipmentRejectedErrorType:        true,
+	OrderRejectedErrorType:           true,
+	OrderCancelledErrorType:          true,
+	InvalidStatusTransitionErrorType: true,
+	DuplicateOrderErrorType:          true,
+}
+
+// Reasons a user cannot place orders
+const (
+	InvalidUserNotFound  = "NOT_FOUND"
+	InvalidUserNoAddress = "NO_ADDRESS"
+	InvalidUserBlocked   = "BLOCKED"
+)
+
+// Reasons a coupon cannot be applied to an order
+const (
+	InvalidCouponNotFound      = "NOT_FOUND"
+	InvalidCouponInactive      = "INACTIVE"
+	InvalidCouponUsageLimit    = "USAGE_LIMIT"
+	InvalidCouponNotApplicable = "NOT_APPLICABLE"
+)
+
+// InvalidUser describes why a user cannot place orders
+type InvalidUser struct {
+	UserID uuid.UUID
+	Reason string
+}
+
+// ProductNotFound names the ordered product that does not exist
+type ProductNotFound struct {
+	ProductID uuid.UUID
+}
+
+// InsufficientStock describes an order line there is not enough stock for
+type InsufficientStock struct {
+	ProductID uuid.UUID
+	Available int
+	Requested int
+}
+
+// InvalidCoupon describes why a coupon cannot be applied to the order
+type InvalidCoupon struct {
+	CouponCode string
+	Reason     string
+}
+
+// UnsupportedCurrency names a currency pair there is no exchange rate for
+type UnsupportedCurrency struct {
+	From string
+	To   string
+}
+
+// PaymentDeclined describes a payment the gateway declined
+type PaymentDeclined struct {
+	OrderID uuid.UUID
+	Amount  model.Money
+}
+
+// ShipmentRejected names the order the carrier refused to ship
+type ShipmentRejected struct {
+	OrderID uuid.UUID
+}
+
+// InvalidStatusTransition names the status an order cannot move to from its current one
+type InvalidStatusTransition struct {
+	From string
+	To   string
+}
+
+// DuplicateOrder names the order another workflow created with the same
+// idempotency key, the workflow that owns it, and the order's status
+type DuplicateOrder struct {
+	OrderID    uuid.UUID
+	WorkflowID string
+	Status     string
+}
+
+// newInvalidOrderError returns the error for a request that can never be fulfilled as sent.
+func newInvalidOrderError(format string, args ...interface{}) error {
+	return temporal.NewNonRetryableApplicationError(fmt.Sprintf(format, args...), InvalidOrderErrorType, nil)
+}
+
+// newInvalidUserError returns the error for a user who cannot place orders.
+func newInvalidUserError(userID uuid.UUID, reason, message string) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("user %s %s", userID, message),
+		InvalidUserErrorType,
+		nil,
+		InvalidUser{UserID: userID, Reason: reason},
+	)
+}
+
+// newProductNotFoundError returns the error for an ordered product that does not exist.
+func newProductNotFoundError(productID uuid.UUID, cause error) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("failed to get product with UUID %s", productID),
+		ProductNotFoundErrorType,
+		cause,
+		ProductNotFound{ProductID: productID},
+	)
+}
+
+// newInsufficientStockError returns the error for an order line there is not enough stock for.
+func newInsufficientStockError(productID uuid.UUID, available, requested int) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("insufficient stock for product %s: available %d, requested %d", productID, available, requested),
+		InsufficientStockErrorType,
+		nil,
+		InsufficientStock{ProductID: productID, Available: available, Requested: requested},
+	)
+}
+
+// newInvalidCouponError returns the error for a coupon that cannot be applied to the order.
+func newInvalidCouponError(code, reason, message string, cause error) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("coupon %s %s", code, message),
+		InvalidCouponErrorType,
+		cause,
+		InvalidCoupon{CouponCode: code, Reason: reason},
+	)
+}
+
+// newUnsupportedCurrencyError returns the error for a price that cannot be converted into the order currency.
+func newUnsupportedCurrencyError(from, to string, cause error) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("cannot convert %s into %s", from, to),
+		UnsupportedCurrencyErrorType,
+		cause,
+		UnsupportedCurrency{From: from, To: to},
+	)
+}
+
+// newPaymentDeclinedError returns the error for a payment the gateway declined.
+func newPaymentDeclinedError(orderID uuid.UUID, amount model.Money, cause error) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("payment of %s declined for order %s", amount, orderID),
+		PaymentDeclinedErrorType,
+		cause,
+		PaymentDeclined{OrderID: orderID, Amount: amount},
+	)
+}
+
+// newShipmentRejectedError returns the error for an order the carrier refused to ship.
+func newShipmentRejectedError(orderID uuid.UUID, cause error) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("shipment rejected for order %s", orderID),
+		ShipmentRejectedErrorType,
+		cause,
+		ShipmentRejected{OrderID: orderID},
+	)
+}
+
+// newInvalidStatusTransitionError returns the error for an order that cannot
+// move from status from to status to. It wraps ErrInvalidStatusTransition.
+func newInvalidStatusTransitionError(from, to string) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("order status cannot move from %q to %q", from, to),
+		InvalidStatusTransitionErrorType,
+		ErrInvalidStatusTransition,
+		InvalidStatusTransition{From: from, To: to},
+	)
+}
+
+// newDuplicateOrderError returns the error for a request whose order is owned by another workflow.
+func newDuplicateOrderError(order Order) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("order %s belongs to workflow %q", order.ID, order.WorkflowID),
+		DuplicateOrderErrorType,
+		nil,
+		DuplicateOrder{OrderID: order.ID, WorkflowID: order.WorkflowID, Status: order.Status},
+	)
+}
+
+// wrapFault adds msg to an infrastructure fault. A business error is returned
+// as it is: once wrapped it would no longer be recognised as non-retryable.
+func wrapFault(err error, msg string) error {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && businessErrorTypes[appErr.Type()] {
+		return appErr
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// orderFailure returns the business error behind an activity failure, so that
+// OrderWorkflow sees its type instead of an activity error wrapping it. Other
+// errors are returned unchanged.
+func orderFailure(err error) error {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && businessErrorTypes[appErr.Type()] {
+		return appErr
+	}
+	return err
+}
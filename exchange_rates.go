@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"sktemporal/model"
+)
+
+// ErrNoExchangeRate is returned by an ExchangeRateProvider that has no rate for a currency pair.
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// ExchangeRateProvider quotes exchange rates. Implementations wrap a rates feed.
+type ExchangeRateProvider interface {
+	// Rate returns the rate converting amounts in from into to.
+	Rate(ctx context.Context, from, to string) (model.ExchangeRate, error)
+}
+
+// StaticExchangeRates is an ExchangeRateProvider serving a fixed table, e.g.
+// loaded from a file with LoadExchangeRatesFile. Rates maps a source currency
+// to the rate of each target currency, as decimal strings:
+//
+//	{"asOf": "2026-10-01", "rates": {"INR": {"USD": "0.012"}}}
+//
+// A missing pair is served as the inverse of the opposite pair when that is known.
+type StaticExchangeRates struct {
+	AsOf  string                       `json:"asOf"`
+	Rates map[string]map[string]string `json:"rates"`
+}
+
+// LoadExchangeRatesFile reads StaticExchangeRates from a JSON file and checks every rate.
+func LoadExchangeRatesFile(path string) (*StaticExchangeRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchange rates: %w", err)
+	}
+	var rates StaticExchangeRates
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("failed to parse exchange rates %s: %w", path, err)
+	}
+	for from, targets := range rates.Rates {
+		for to, rate := range targets {
+			if _, err := (model.ExchangeRate{From: from, To: to, Rate: rate}).Rat(); err != nil {
+				return nil, fmt.Errorf("exchange rates %s: %w", path, err)
+			}
+		}
+	}
+	return &rates, nil
+}
+
+// Rate implements ExchangeRateProvider.
+func (s *StaticExchangeRates) Rate(ctx context.Context, from, to string) (model.ExchangeRate, error) {
+	if from == to {
+		return model.ExchangeRate{From: from, To: to, Rate: "1", AsOf: s.AsOf}, nil
+	}
+	if rate, ok := s.Rates[from][to]; ok {
+		return model.ExchangeRate{From: from, To: to, Rate: rate, AsOf: s.AsOf}, nil
+	}
+	if inverse, ok := s.Rates[to][from]; ok {
+		r, err := (model.ExchangeRate{From: to, To: from, Rate: inverse}).Rat()
+		if err != nil {
+			return model.ExchangeRate{}, err
+		}
+		return model.ExchangeRate{From: from, To: to, Rate: new(big.Rat).Inv(r).RatString(), AsOf: s.AsOf}, nil
+	}
+	return model.ExchangeRate{}, fmt.Errorf("%w from %s to %s", ErrNoExchangeRate, from, to)
+}
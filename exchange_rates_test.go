@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sktemporal/model"
+)
+
+func TestStaticExchangeRates_Rate(t *testing.T) {
+	rates := &StaticExchangeRates{
+		AsOf:  "2026-10-01",
+		Rates: map[string]map[string]string{"INR": {"USD": "0.0125"}},
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+		wantErr  error
+	}{
+		{name: "same currency", from: "USD", to: "USD", want: "1"},
+		{name: "direct pair", from: "INR", to: "USD", want: "0.0125"},
+		{name: "inverse pair", from: "USD", to: "INR", want: "80"},
+		{name: "unknown pair", from: "INR", to: "EUR", wantErr: ErrNoExchangeRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rates.Rate(context.Background(), tt.from, tt.to)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Rate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rate() error = %v", err)
+			}
+			want := model.ExchangeRate{From: tt.from, To: tt.to, Rate: tt.want, AsOf: "2026-10-01"}
+			if got != want {
+				t.Errorf("Rate() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadExchangeRatesFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(valid, []byte(`{"asOf": "2026-10-01", "rates": {"INR": {"USD": "0.012"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte(`{"rates": {"INR": {"USD": "-1"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rates, err := LoadExchangeRatesFile(valid)
+	if err != nil {
+		t.Fatalf("LoadExchangeRatesFile(valid) error = %v", err)
+	}
+	if rates.AsOf != "2026-10-01" || rates.Rates["INR"]["USD"] != "0.012" {
+		t.Errorf("LoadExchangeRatesFile(valid) = %+v", rates)
+	}
+	if _, err := LoadExchangeRatesFile(invalid); err == nil {
+		t.Error("LoadExchangeRatesFile(invalid) error = nil, want error")
+	}
+	if _, err := LoadExchangeRatesFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadExchangeRatesFile(missing) error = nil, want error")
+	}
+}
+
+func TestLoadExchangeRatesFile_ShippedFile(t *testing.T) {
+	if _, err := LoadExchangeRatesFile("exchange-rates.json"); err != nil {
+		t.Fatalf("LoadExchangeRatesFile() error = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/workflow"
+)
+
+// NewLogger returns a logger writing JSON lines to w, which the worker hands to
+// the Temporal client. Workflow and activity loggers are derived from it, so
+// both end up in the same sink. Lines below level (debug, info, warn or error)
+// are dropped; an unknown level means info.
+func NewLogger(w io.Writer, level string) log.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		l = slog.LevelInfo
+	}
+	return log.NewStructuredLogger(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})))
+}
+
+// orderLogger logs for OrderWorkflow. It wraps the workflow logger, which is
+// tagged with the workflow and run IDs and drops lines while the workflow is
+// replaying, and tags every line with the order ID and the current step.
+type orderLogger struct {
+	logger   log.Logger
+	orderID  uuid.UUID
+	progress *OrderProgress
+}
+
+// newOrderLogger returns the logger of the order workflow running in ctx;
+// progress is read on every line to tag it with the step.
+func newOrderLogger(ctx workflow.Context, orderID uuid.UUID, progress *OrderProgress) *orderLogger {
+	return &orderLogger{logger: workflow.GetLogger(ctx), orderID: orderID, progress: progress}
+}
+
+func (l *orderLogger) tags(keyvals []interface{}) []interface{} {
+	return append([]interface{}{"OrderID", l.orderID, "Step", l.progress.Step}, keyvals...)
+}
+
+// Debug implements log.Logger.
+func (l *orderLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debug(msg, l.tags(keyvals)...)
+}
+
+// Info implements log.Logger.
+func (l *orderLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Info(msg, l.tags(keyvals)...)
+}
+
+// Warn implements log.Logger.
+func (l *orderLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warn(msg, l.tags(keyvals)...)
+}
+
+// Error implements log.Logger.
+func (l *orderLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error(msg, l.tags(keyvals)...)
+}
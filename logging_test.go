@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// logLines decodes the JSON lines written by a NewLogger logger.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{level: "debug", want: []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{level: "warn", want: []string{"WARN", "ERROR"}},
+		{level: "ERROR", want: []string{"ERROR"}},
+		{level: "verbose", want: []string{"INFO", "WARN", "ERROR"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(&buf, tt.level)
+			logger.Debug("message", "key", "value")
+			logger.Info("message", "key", "value")
+			logger.Warn("message", "key", "value")
+			logger.Error("message", "key", "value")
+
+			var got []string
+			for _, line := range logLines(t, &buf) {
+				if line["msg"] != "message" || line["key"] != "value" {
+					t.Errorf("unexpected line %v", line)
+				}
+				got = append(got, line["level"].(string))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("levels = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("levels = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
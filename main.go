@@ -1,5 +1,17 @@
 package main
 
+import "os"
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			runReconcile()
+			return
+		case "adjust-stock":
+			runAdjustStock(os.Args[2:])
+			return
+		}
+	}
 	startWorker()
 }
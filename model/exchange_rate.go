@@ -0,0 +1,41 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ExchangeRate is the price of one unit of From in To. Rate is a decimal (or
+// fraction) string so that the rate snapshotted on an order is kept exactly.
+type ExchangeRate struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Rate string `json:"rate"`
+	// AsOf tells when the rate was published, as given by the provider
+	AsOf string `json:"asOf,omitempty"`
+}
+
+// Rat returns the rate as a fraction.
+func (r ExchangeRate) Rat() (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q for %s/%s", r.Rate, r.From, r.To)
+	}
+	return rate, nil
+}
+
+// Convert converts an amount in From into To, rounding with Round.
+func (r ExchangeRate) Convert(m Money) (Money, error) {
+	if m.Currency != r.From {
+		return Money{}, fmt.Errorf("%w: cannot convert %s with a %s/%s rate", ErrCurrencyMismatch, m.Currency, r.From, r.To)
+	}
+	rate, err := r.Rat()
+	if err != nil {
+		return Money{}, err
+	}
+	// Minor units of From -> major units -> major units of To -> minor units of To
+	scale := new(big.Rat).SetFrac(big.NewInt(pow10(MinorUnitDigits(r.To))), big.NewInt(pow10(MinorUnitDigits(r.From))))
+	minor := new(big.Rat).SetInt64(m.Amount)
+	minor.Mul(minor, rate).Mul(minor, scale)
+	return Money{Amount: Round(minor), Currency: r.To}, nil
+}
@@ -2,9 +2,78 @@ package model
 
 import "github.com/google/uuid"
 
-// OrderRequest represents the input to the order workflow
+// OrderRequest represents the input to the order workflow. IdempotencyKey is
+// optional; when set, the order ID is derived from it instead of the workflow ID,
+// so that resubmitting the same key never reserves stock for a second order.
+// Currency is the currency the order is priced and charged in, DefaultCurrency
+// when empty. CouponCodes are applied to the order total; codes are not case
+// sensitive.
 type OrderRequest struct {
-	UserID          uuid.UUID `json:"userID"`
-	ProductID       uuid.UUID `json:"productid"`
-	ProductQuantity int       `json:"productQuantity"`
+	UserID         uuid.UUID   `json:"userID"`
+	Items          []OrderItem `json:"items"`
+	IdempotencyKey string      `json:"idempotencyKey,omitempty"`
+	Currency       string      `json:"currency,omitempty"`
+	CouponCodes    []string    `json:"couponCodes,omitempty"`
+}
+
+// OrderItem is a single line of an order. UnitPrice and ProductType are
+// ignored on input and are filled with the product price, converted into the
+// order currency, and the product type snapshotted when inventory is reserved.
+type OrderItem struct {
+	ProductID   uuid.UUID `json:"productID"`
+	Quantity    int       `json:"quantity"`
+	UnitPrice   Money     `json:"unitPrice"`
+	ProductType string    `json:"productType,omitempty"`
+}
+
+// Discount is the amount a coupon took off an order, in the order currency.
+type Discount struct {
+	CouponCode string `json:"couponCode"`
+	Type       string `json:"type"`
+	Amount     Money  `json:"amount"`
+}
+
+// TaxLine is a tax charged on an order: Rate, a decimal fraction such as
+// "0.18", applied to the Taxable amount of the lines the tax covers.
+type TaxLine struct {
+	Name    string `json:"name"`
+	Rate    string `json:"rate"`
+	Taxable Money  `json:"taxable"`
+	Amount  Money  `json:"amount"`
+}
+
+// ApprovalRequest is the payload of the approve and reject signals an approver
+// sends to an order waiting for manual approval.
+type ApprovalRequest struct {
+	Approver string `json:"approver"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// OrderResult is the result of the order workflow. Status is the final status
+// of the order, empty when no order was created. An order that cannot go
+// through for a business reason, or that is rejected or cancelled, has
+// FailureType set to the type of its error, e.g. InsufficientStock.
+type OrderResult struct {
+	OrderID        uuid.UUID            `json:"orderID"`
+	Status         string               `json:"status"`
+	TotalPrice     Money                `json:"totalPrice"`
+	TaxTotal       Money                `json:"taxTotal"`
+	AmountPaid     Money                `json:"amountPaid"`
+	TrackingNumber string               `json:"trackingNumber,omitempty"`
+	Compensations  []CompensationStatus `json:"compensations"`
+	FailureType    string               `json:"failureType,omitempty"`
+	FailureMessage string               `json:"failureMessage,omitempty"`
+}
+
+// CompensationStatus describes a registered compensation and whether it has run
+type CompensationStatus struct {
+	Name  string
+	State string
+	Error string `json:",omitempty"`
+}
+
+// CancelOrderRequest is the payload of the cancel signal sent to a running order workflow
+type CancelOrderRequest struct {
+	Reason      string `json:"reason"`
+	RequestedBy string `json:"requestedBy"`
 }
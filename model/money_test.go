@@ -0,0 +1,170 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     int64
+	}{
+		{"120000.00", "INR", 12000000},
+		{"199.99", "USD", 19999},
+		{"0.1", "USD", 10},
+		{"10", "USD", 1000},
+		{"0.005", "USD", 1},
+		{"0.004", "USD", 0},
+		{"-0.005", "USD", -1},
+		{"1500", "JPY", 1500},
+		{"1.2345", "KWD", 1235},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in+" "+tt.currency, func(t *testing.T) {
+			got, err := ParseMoney(tt.in, tt.currency)
+			if err != nil {
+				t.Fatalf("ParseMoney() error = %v", err)
+			}
+			if got != NewMoney(tt.want, tt.currency) {
+				t.Errorf("ParseMoney() = %+v, want %d %s", got, tt.want, tt.currency)
+			}
+		})
+	}
+
+	if _, err := ParseMoney("12,00", "USD"); err == nil {
+		t.Error("ParseMoney(\"12,00\") error = nil, want error")
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		want     int64
+	}{
+		{5, 2, 3},
+		{-5, 2, -3},
+		{7, 3, 2},
+		{-7, 3, -2},
+		{3, 2, 2},
+		{1, 2, 1},
+		{4, 1, 4},
+	}
+	for _, tt := range tests {
+		if got := Round(big.NewRat(tt.num, tt.den)); got != tt.want {
+			t.Errorf("Round(%d/%d) = %d, want %d", tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a := NewMoney(1999, "USD")
+	b := NewMoney(1, "USD")
+
+	sum, err := a.Add(b)
+	if err != nil || sum != NewMoney(2000, "USD") {
+		t.Errorf("Add() = %v, %v", sum, err)
+	}
+	diff, err := a.Sub(b)
+	if err != nil || diff != NewMoney(1998, "USD") {
+		t.Errorf("Sub() = %v, %v", diff, err)
+	}
+	if got := a.Mul(3); got != NewMoney(5997, "USD") {
+		t.Errorf("Mul() = %v", got)
+	}
+	// 19.99 * 8.25% = 1.649175 -> 1.65
+	if got := a.MulRat(big.NewRat(825, 10000)); got != NewMoney(165, "USD") {
+		t.Errorf("MulRat() = %v", got)
+	}
+	if c, err := a.Cmp(b); err != nil || c != 1 {
+		t.Errorf("Cmp() = %d, %v", c, err)
+	}
+
+	if _, err := a.Add(NewMoney(1, "INR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add() across currencies error = %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := a.Cmp(NewMoney(1, "INR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp() across currencies error = %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(12000000, "INR"), "120000.00"},
+		{NewMoney(5, "USD"), "0.05"},
+		{NewMoney(-1999, "USD"), "-19.99"},
+		{NewMoney(1500, "JPY"), "1500"},
+		{NewMoney(1235, "KWD"), "1.235"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Decimal(); got != tt.want {
+			t.Errorf("%+v.Decimal() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+	if got := NewMoney(1999, "USD").String(); got != "19.99 USD" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(NewMoney(1999, "USD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"amount":1999,"currency":"USD"}` {
+		t.Errorf("Marshal() = %s", data)
+	}
+
+	var m Money
+	if err := json.Unmarshal(data, &m); err != nil || m != NewMoney(1999, "USD") {
+		t.Errorf("Unmarshal() = %+v, %v", m, err)
+	}
+
+	// Order items stored before amounts were exact hold a bare price
+	var legacy OrderItem
+	if err := json.Unmarshal([]byte(`{"quantity":2,"unitPrice":120000}`), &legacy); err != nil {
+		t.Fatalf("Unmarshal() legacy error = %v", err)
+	}
+	if legacy.UnitPrice != NewMoney(12000000, DefaultCurrency) {
+		t.Errorf("legacy UnitPrice = %+v", legacy.UnitPrice)
+	}
+}
+
+func TestExchangeRate_Convert(t *testing.T) {
+	tests := []struct {
+		name string
+		rate ExchangeRate
+		in   Money
+		want Money
+	}{
+		{"INR to USD", ExchangeRate{From: "INR", To: "USD", Rate: "0.012"}, NewMoney(12000000, "INR"), NewMoney(144000, "USD")},
+		{"rounds half away from zero", ExchangeRate{From: "INR", To: "USD", Rate: "0.012"}, NewMoney(125, "INR"), NewMoney(2, "USD")},
+		{"fraction rate", ExchangeRate{From: "USD", To: "INR", Rate: "250/3"}, NewMoney(300, "USD"), NewMoney(25000, "INR")},
+		{"to zero-digit currency", ExchangeRate{From: "USD", To: "JPY", Rate: "150"}, NewMoney(1999, "USD"), NewMoney(2999, "JPY")},
+		{"from zero-digit currency", ExchangeRate{From: "JPY", To: "USD", Rate: "0.0067"}, NewMoney(1500, "JPY"), NewMoney(1005, "USD")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rate.Convert(tt.in)
+			if err != nil {
+				t.Fatalf("Convert() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := (ExchangeRate{From: "USD", To: "INR", Rate: "83"}).Convert(NewMoney(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Convert() wrong currency error = %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := (ExchangeRate{From: "USD", To: "INR", Rate: "-1"}).Convert(NewMoney(1, "USD")); err == nil {
+		t.Error("Convert() with negative rate error = nil, want error")
+	}
+}
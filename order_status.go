@@ -0,0 +1,56 @@
+package main
+
+import "errors"
+
+// Order statuses, matching the order_status enum in the app database
+const (
+	StatusAddedToCart       = "ADDED_TO_CART"
+	StatusPaymentFailed     = "PAYMENT_FAILED"
+	StatusShippingInitiated = "SHIPPING_INITIATED"
+	StatusShipped           = "SHIPPED"
+	StatusShipmentCancelled = "SHIPMENT_CANCELLED"
+	StatusOrderDelivered    = "ORDER_DELIVERED"
+	StatusCancelled         = "CANCELLED"
+)
+
+// ErrInvalidStatusTransition is returned when an order would move to a status
+// the state machine does not allow from its current one.
+var ErrInvalidStatusTransition = errors.New("invalid order status transition")
+
+// orderStatusTransitions lists the statuses an order may move to from each
+// status. The empty status stands for an order that does not exist yet.
+// ORDER_DELIVERED and CANCELLED are final.
+var orderStatusTransitions = map[string][]string{
+	"":                      {StatusAddedToCart},
+	StatusAddedToCart:       {StatusShippingInitiated, StatusPaymentFailed, StatusCancelled},
+	StatusShippingInitiated: {StatusShipped, StatusPaymentFailed, StatusCancelled},
+	StatusShipped:           {StatusOrderDelivered, StatusShipmentCancelled, StatusPaymentFailed, StatusCancelled},
+	StatusShipmentCancelled: {StatusPaymentFailed, StatusCancelled},
+	StatusPaymentFailed:     {StatusCancelled},
+	StatusOrderDelivered:    {},
+	StatusCancelled:         {},
+}
+
+// CanTransition reports whether an order may move from one status to another.
+// Staying in the same status is allowed so that retried activities succeed.
+func CanTransition(from, to string) bool {
+	if from == to {
+		_, known := orderStatusTransitions[to]
+		return known && to != ""
+	}
+	for _, next := range orderStatusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStatusTransition returns a non-retryable InvalidStatusTransition error,
+// wrapping ErrInvalidStatusTransition, when CanTransition is false.
+func checkStatusTransition(from, to string) error {
+	if !CanTransition(from, to) {
+		return newInvalidStatusTransitionError(from, to)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"", StatusAddedToCart, true},
+		{"", StatusShipped, false},
+		{"", "", false},
+		{StatusAddedToCart, StatusShippingInitiated, true},
+		{StatusAddedToCart, StatusPaymentFailed, true},
+		{StatusAddedToCart, StatusCancelled, true},
+		{StatusAddedToCart, StatusShipped, false},
+		{StatusShippingInitiated, StatusShipped, true},
+		{StatusShippingInitiated, StatusPaymentFailed, true},
+		{StatusShipped, StatusOrderDelivered, true},
+		{StatusShipped, StatusPaymentFailed, true},
+		{StatusShipped, StatusShipmentCancelled, true},
+		{StatusShipmentCancelled, StatusPaymentFailed, true},
+		{StatusShipmentCancelled, StatusShipped, false},
+		{StatusPaymentFailed, StatusCancelled, true},
+		{StatusPaymentFailed, StatusShippingInitiated, false},
+		{StatusOrderDelivered, StatusPaymentFailed, false},
+		{StatusOrderDelivered, StatusCancelled, false},
+		{StatusCancelled, StatusAddedToCart, false},
+		{StatusShipped, StatusShipped, true},
+		{StatusCancelled, StatusCancelled, true},
+		{"UNKNOWN", "UNKNOWN", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+			err := checkStatusTransition(tt.from, tt.to)
+			if tt.want != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidStatusTransition)) {
+				t.Errorf("checkStatusTransition(%q, %q) error = %v", tt.from, tt.to, err)
+			}
+		})
+	}
+}
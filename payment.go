@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// ErrPaymentDeclined is returned by a PaymentGateway when the provider declines the payment.
+var ErrPaymentDeclined = errors.New("payment declined")
+
+// ErrCaptureNotFound is returned by PaymentGateway.FindCapture when no payment
+// of the order has been captured.
+var ErrCaptureNotFound = errors.New("capture not found")
+
+// PaymentGateway moves money for an order. Implementations wrap a payment provider.
+type PaymentGateway interface {
+	// Authorize reserves the amount on the customer's payment method and returns
+	// the gateway authorization ID. OrderID is used as the idempotency key, so
+	// authorizing the same order twice returns the same authorization.
+	Authorize(ctx context.Context, req PaymentAuthorization) (string, error)
+	// Capture settles an authorization and returns the gateway capture transaction ID.
+	Capture(ctx context.Context, authorizationID string, amount model.Money) (string, error)
+	// Refund returns a captured amount and returns the gateway refund transaction ID.
+	Refund(ctx context.Context, captureID string, amount model.Money) (string, error)
+	// FindCapture returns the captured payment of the order, looked up by the
+	// OrderID it was authorized with, or ErrCaptureNotFound.
+	FindCapture(ctx context.Context, orderID uuid.UUID) (PaymentCapture, error)
+}
+
+// PaymentAuthorization is the input to PaymentGateway.Authorize
+type PaymentAuthorization struct {
+	OrderID uuid.UUID
+	UserID  uuid.UUID
+	Amount  model.Money
+}
+
+// PaymentCapture is a captured payment returned by PaymentGateway.FindCapture
+type PaymentCapture struct {
+	AuthorizationID string
+	CaptureID       string
+	Amount          model.Money
+}
+
+// FakePaymentGateway is an in-process PaymentGateway for tests and local runs.
+type FakePaymentGateway struct {
+	// DeclineAll declines every authorization
+	DeclineAll bool
+	// DeclineAbove declines authorizations for more than this amount when
+	// positive; authorizations in another currency fail with an error
+	DeclineAbove model.Money
+	// Latency is waited before every call. A latency longer than the activity
+	// timeout simulates a gateway that does not answer in time.
+	Latency time.Duration
+
+	mu             sync.Mutex
+	seq            int
+	authorizations map[string]fakeAuthorization
+	captures       map[string]model.Money
+	refunds        map[string]string
+}
+
+type fakeAuthorization struct {
+	orderID uuid.UUID
+	amount  model.Money
+}
+
+// NewFakePaymentGateway returns a FakePaymentGateway that approves every payment immediately.
+func NewFakePaymentGateway() *FakePaymentGateway {
+	return &FakePaymentGateway{}
+}
+
+// Authorize implements PaymentGateway.
+func (g *FakePaymentGateway) Authorize(ctx context.Context, req PaymentAuthorization) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+	above, err := g.aboveLimit(req.Amount)
+	if err != nil {
+		return "", err
+	}
+	if g.DeclineAll || above {
+		return "", fmt.Errorf("%w: amount %s for order %s", ErrPaymentDeclined, req.Amount, req.OrderID)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	for id, auth := range g.authorizations {
+		if auth.orderID == req.OrderID {
+			return id, nil
+		}
+	}
+	id := g.nextID("auth")
+	g.authorizations[id] = fakeAuthorization{orderID: req.OrderID, amount: req.Amount}
+	return id, nil
+}
+
+// Capture implements PaymentGateway.
+func (g *FakePaymentGateway) Capture(ctx context.Context, authorizationID string, amount model.Money) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	auth, ok := g.authorizations[authorizationID]
+	if !ok {
+		return "", fmt.Errorf("unknown authorization %s", authorizationID)
+	}
+	if c, err := amount.Cmp(auth.amount); err != nil || c > 0 {
+		return "", fmt.Errorf("capture amount %s exceeds authorized %s", amount, auth.amount)
+	}
+	// Captures are keyed by authorization so a retried capture is not charged twice
+	captureID := "capture-" + authorizationID
+	g.captures[captureID] = amount
+	return captureID, nil
+}
+
+// Refund implements PaymentGateway.
+func (g *FakePaymentGateway) Refund(ctx context.Context, captureID string, amount model.Money) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	captured, ok := g.captures[captureID]
+	if !ok {
+		return "", fmt.Errorf("unknown capture %s", captureID)
+	}
+	if c, err := amount.Cmp(captured); err != nil || c > 0 {
+		return "", fmt.Errorf("refund amount %s exceeds captured %s", amount, captured)
+	}
+	if refundID, ok := g.refunds[captureID]; ok {
+		return refundID, nil
+	}
+	refundID := g.nextID("refund")
+	g.refunds[captureID] = refundID
+	return refundID, nil
+}
+
+// FindCapture implements PaymentGateway.
+func (g *FakePaymentGateway) FindCapture(ctx context.Context, orderID uuid.UUID) (PaymentCapture, error) {
+	if err := g.wait(ctx); err != nil {
+		return PaymentCapture{}, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	for id, auth := range g.authorizations {
+		if auth.orderID != orderID {
+			continue
+		}
+		captureID := "capture-" + id
+		if amount, ok := g.captures[captureID]; ok {
+			return PaymentCapture{AuthorizationID: id, CaptureID: captureID, Amount: amount}, nil
+		}
+	}
+	return PaymentCapture{}, fmt.Errorf("order %s: %w", orderID, ErrCaptureNotFound)
+}
+
+// aboveLimit reports whether amount exceeds a positive DeclineAbove. An amount
+// in another currency cannot be checked against the limit, which no retry can
+// change, so it is a non-retryable UnsupportedCurrency error.
+func (g *FakePaymentGateway) aboveLimit(amount model.Money) (bool, error) {
+	if g.DeclineAbove.Amount <= 0 {
+		return false, nil
+	}
+	c, err := amount.Cmp(g.DeclineAbove)
+	if err != nil {
+		return false, newUnsupportedCurrencyError(amount.Currency, g.DeclineAbove.Currency,
+			fmt.Errorf("cannot check amount against decline limit %s: %w", g.DeclineAbove, err))
+	}
+	return c > 0, nil
+}
+
+func (g *FakePaymentGateway) wait(ctx context.Context) error {
+	if g.Latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(g.Latency):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("payment gateway timed out: %w", ctx.Err())
+	}
+}
+
+func (g *FakePaymentGateway) init() {
+	if g.authorizations == nil {
+		g.authorizations = make(map[string]fakeAuthorization)
+		g.captures = make(map[string]model.Money)
+		g.refunds = make(map[string]string)
+	}
+}
+
+func (g *FakePaymentGateway) nextID(prefix string) string {
+	g.seq++
+	return fmt.Sprintf("%s-%d", prefix, g.seq)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestFakePaymentGateway_AuthorizeCaptureRefund(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakePaymentGateway()
+	orderID := uuid.New()
+
+	authID, err := g.Authorize(ctx, PaymentAuthorization{OrderID: orderID, Amount: inr(10000)})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	again, err := g.Authorize(ctx, PaymentAuthorization{OrderID: orderID, Amount: inr(10000)})
+	if err != nil {
+		t.Fatalf("Authorize() retry error = %v", err)
+	}
+	if again != authID {
+		t.Errorf("Authorize() retry = %q, want same authorization %q", again, authID)
+	}
+
+	captureID, err := g.Capture(ctx, authID, inr(10000))
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+	refundID, err := g.Refund(ctx, captureID, inr(10000))
+	if err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	againRefund, err := g.Refund(ctx, captureID, inr(10000))
+	if err != nil {
+		t.Fatalf("Refund() retry error = %v", err)
+	}
+	if againRefund != refundID {
+		t.Errorf("Refund() retry = %q, want same refund %q", againRefund, refundID)
+	}
+}
+
+func TestFakePaymentGateway_FindCapture(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakePaymentGateway()
+	orderID := uuid.New()
+
+	if _, err := g.FindCapture(ctx, orderID); !errors.Is(err, ErrCaptureNotFound) {
+		t.Fatalf("FindCapture() before authorizing error = %v, want ErrCaptureNotFound", err)
+	}
+	authID, err := g.Authorize(ctx, PaymentAuthorization{OrderID: orderID, Amount: inr(10000)})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if _, err := g.FindCapture(ctx, orderID); !errors.Is(err, ErrCaptureNotFound) {
+		t.Fatalf("FindCapture() before capturing error = %v, want ErrCaptureNotFound", err)
+	}
+	captureID, err := g.Capture(ctx, authID, inr(10000))
+	if err != nil {
+		t.Fatalf("Capture() error = %v", err)
+	}
+
+	got, err := g.FindCapture(ctx, orderID)
+	if err != nil {
+		t.Fatalf("FindCapture() error = %v", err)
+	}
+	want := PaymentCapture{AuthorizationID: authID, CaptureID: captureID, Amount: inr(10000)}
+	if got != want {
+		t.Errorf("FindCapture() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFakePaymentGateway_Declines(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		g       *FakePaymentGateway
+		amount  model.Money
+		decline bool
+	}{
+		{name: "approves by default", g: &FakePaymentGateway{}, amount: inr(100000000000)},
+		{name: "declines all", g: &FakePaymentGateway{DeclineAll: true}, amount: inr(100), decline: true},
+		{name: "declines above threshold", g: &FakePaymentGateway{DeclineAbove: inr(50000)}, amount: inr(50001), decline: true},
+		{name: "approves at threshold", g: &FakePaymentGateway{DeclineAbove: inr(50000)}, amount: inr(50000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.g.Authorize(ctx, PaymentAuthorization{OrderID: uuid.New(), Amount: tt.amount})
+			if got := errors.Is(err, ErrPaymentDeclined); got != tt.decline {
+				t.Errorf("Authorize() error = %v, want declined %v", err, tt.decline)
+			}
+		})
+	}
+}
+
+func TestFakePaymentGateway_DeclineAboveInAnotherCurrency_ReturnsError(t *testing.T) {
+	g := &FakePaymentGateway{DeclineAbove: inr(50000)}
+
+	_, err := g.Authorize(context.Background(), PaymentAuthorization{OrderID: uuid.New(), Amount: model.NewMoney(100, "USD")})
+	if err == nil {
+		t.Fatal("Authorize() in USD against an INR limit succeeded, want error")
+	}
+	if errors.Is(err, ErrPaymentDeclined) || !errors.Is(err, model.ErrCurrencyMismatch) {
+		t.Errorf("Authorize() error = %v, want a currency mismatch, not a decline", err)
+	}
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != UnsupportedCurrencyErrorType || !appErr.NonRetryable() {
+		t.Errorf("Authorize() error = %v, want a non-retryable %s error", err, UnsupportedCurrencyErrorType)
+	}
+}
+
+func TestFakePaymentGateway_UnknownReferences(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakePaymentGateway()
+
+	if _, err := g.Capture(ctx, "auth-missing", inr(1000)); err == nil {
+		t.Error("Capture() with unknown authorization succeeded, want error")
+	}
+	if _, err := g.Refund(ctx, "capture-missing", inr(1000)); err == nil {
+		t.Error("Refund() with unknown capture succeeded, want error")
+	}
+}
+
+func TestFakePaymentGateway_LatencyHonoursDeadline(t *testing.T) {
+	g := &FakePaymentGateway{Latency: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := g.Authorize(ctx, PaymentAuthorization{OrderID: uuid.New(), Amount: inr(100)})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Authorize() error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestFakePaymentGateway_RejectsOtherCurrency(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakePaymentGateway()
+
+	authID, err := g.Authorize(ctx, PaymentAuthorization{OrderID: uuid.New(), Amount: inr(10000)})
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if _, err := g.Capture(ctx, authID, model.NewMoney(100, "USD")); err == nil {
+		t.Error("Capture() in another currency succeeded, want error")
+	}
+}
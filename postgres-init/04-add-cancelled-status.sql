@@ -0,0 +1,5 @@
+-- Connect to appdb and extend the order status enum
+\c appdb
+
+-- Orders cancelled through the workflow cancel signal end in this status
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'CANCELLED';
@@ -0,0 +1,7 @@
+-- Connect to appdb and add payment gateway references to orders
+\c appdb
+
+-- Gateway transaction IDs written by DeductPaymentActivity and RefundPaymentActivity
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_authorization_id VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_capture_id VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_refund_id VARCHAR(255);
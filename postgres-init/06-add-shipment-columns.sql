@@ -0,0 +1,12 @@
+-- Connect to appdb and add carrier shipment details to orders
+\c appdb
+
+-- ShippingActivity books a shipment and moves the order to SHIPPED; delivery is
+-- reported separately by the carrier
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'SHIPPED';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipment_id VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_label_url TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_orders_tracking_number ON orders(tracking_number);
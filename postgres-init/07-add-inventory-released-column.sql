@@ -0,0 +1,6 @@
+-- Connect to appdb and track whether an order's reserved stock has been returned
+\c appdb
+
+-- ReleaseInventoryActivity sets the flag in the same transaction that restores
+-- the stock, so a retried compensation cannot release it twice
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS inventory_released BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,25 @@
+-- Connect to appdb and create the inventory movement ledger
+\c appdb
+
+-- Every change to products.items_available is recorded here in the same
+-- transaction, so stock can be explained and recomputed from the ledger
+CREATE TABLE IF NOT EXISTS inventory_movements (
+    id BIGSERIAL PRIMARY KEY,
+    order_id UUID,
+    product_id UUID NOT NULL REFERENCES products(id),
+    delta INTEGER NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    workflow_id VARCHAR(255),
+    run_id VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_product_id ON inventory_movements(product_id);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_order_id ON inventory_movements(order_id);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_created_at ON inventory_movements(created_at);
+
+-- Open the ledger with the stock each product has today
+INSERT INTO inventory_movements (product_id, delta, reason)
+SELECT p.id, p.items_available, 'OPENING_BALANCE'
+FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM inventory_movements m WHERE m.product_id = p.id);
@@ -0,0 +1,23 @@
+-- Connect to appdb and create the order status timeline
+\c appdb
+
+-- One row per status change, written in the same transaction as the change.
+-- from_status is NULL for the row written when the order is created.
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders(id),
+    from_status order_status,
+    to_status order_status NOT NULL,
+    reason TEXT NOT NULL,
+    workflow_id VARCHAR(255),
+    run_id VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id, created_at);
+
+-- Start the timeline of existing orders at their current status
+INSERT INTO order_status_history (order_id, to_status, reason, created_at)
+SELECT o.id, o.status, 'backfilled', o.updated_at
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id);
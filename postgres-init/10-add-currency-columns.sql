@@ -0,0 +1,13 @@
+-- Connect to appdb and add currencies to products and orders
+\c appdb
+
+-- Existing prices are in INR. Three decimals fit currencies with a
+-- thousandth minor unit (e.g. KWD).
+ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'INR';
+ALTER TABLE products ALTER COLUMN price TYPE DECIMAL(13, 3);
+
+-- Orders are priced in the currency requested by the customer; the rates used
+-- to convert product prices are snapshotted on the order
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'INR';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS exchange_rates JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE orders ALTER COLUMN total_price TYPE DECIMAL(13, 3);
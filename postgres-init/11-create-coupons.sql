@@ -0,0 +1,40 @@
+-- Connect to appdb and create the coupon tables
+\c appdb
+
+-- Codes are stored upper case. Which columns apply depends on the type:
+-- percent_off for PERCENTAGE, amount_off and currency for FIXED_AMOUNT, and
+-- product_id, buy_quantity and free_quantity for BUY_X_GET_Y.
+-- max_uses_per_user = 0 means no limit.
+CREATE TABLE IF NOT EXISTS coupons (
+    code VARCHAR(64) PRIMARY KEY CHECK (code = UPPER(code)),
+    type VARCHAR(32) NOT NULL CHECK (type IN ('PERCENTAGE', 'FIXED_AMOUNT', 'BUY_X_GET_Y')),
+    percent_off DECIMAL(5, 2) CHECK (percent_off > 0 AND percent_off <= 100),
+    amount_off DECIMAL(13, 3) CHECK (amount_off > 0),
+    currency CHAR(3),
+    product_id UUID REFERENCES products(id),
+    buy_quantity INTEGER CHECK (buy_quantity > 0),
+    free_quantity INTEGER CHECK (free_quantity > 0),
+    max_uses_per_user INTEGER NOT NULL DEFAULT 0 CHECK (max_uses_per_user >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per coupon used on an order. released_at is set when the order is
+-- rolled back, which gives the use back to the user.
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    coupon_code VARCHAR(64) NOT NULL REFERENCES coupons(code),
+    user_id UUID NOT NULL,
+    order_id UUID NOT NULL REFERENCES orders(id),
+    amount DECIMAL(13, 3) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    released_at TIMESTAMPTZ,
+    UNIQUE (coupon_code, order_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_user ON coupon_redemptions(coupon_code, user_id) WHERE released_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_order_id ON coupon_redemptions(order_id);
+
+-- Discounts applied to the order total, as a JSON breakdown per coupon
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discounts JSONB NOT NULL DEFAULT '[]';
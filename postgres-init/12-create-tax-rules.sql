@@ -0,0 +1,30 @@
+-- Connect to appdb and create the tax rules
+\c appdb
+
+-- A rule applies to addresses whose last comma-separated part is country and,
+-- when region is set, that contain region as another part. product_type NULL
+-- applies to every product. For each tax name, the most specific matching rule
+-- wins; rules with different names add up. rate is a fraction, e.g. 0.18.
+CREATE TABLE IF NOT EXISTS tax_rules (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    country VARCHAR(64) NOT NULL,
+    region VARCHAR(64),
+    product_type product_type,
+    rate DECIMAL(6, 5) NOT NULL CHECK (rate >= 0 AND rate < 1),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE NULLS NOT DISTINCT (name, country, region, product_type)
+);
+
+-- Taxes charged on the order, as a JSON breakdown per tax. Once
+-- tax_calculated is set, total_price includes them.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS taxes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_calculated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Indian GST by product type and a flat US sales tax as a starting point
+INSERT INTO tax_rules (name, country, region, product_type, rate) VALUES
+    ('GST', 'India', NULL, NULL, 0.18),
+    ('GST', 'India', NULL, 'CLOTHS', 0.05),
+    ('GST', 'India', NULL, 'KITCHEN_ITEMS', 0.12),
+    ('SALES_TAX', 'USA', NULL, NULL, 0.07)
+ON CONFLICT DO NOTHING;
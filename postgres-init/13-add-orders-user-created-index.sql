@@ -0,0 +1,5 @@
+-- Connect to appdb and index orders by user and creation time
+\c appdb
+
+-- The risk scorer counts each user's recent orders to detect order velocity
+CREATE INDEX IF NOT EXISTS idx_orders_user_created_at ON orders(userID, created_at);
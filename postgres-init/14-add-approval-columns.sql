@@ -0,0 +1,10 @@
+-- Connect to appdb and record the outcome of manual order approvals
+\c appdb
+
+-- Set by RecordApprovalActivity when an order held for approval is approved,
+-- rejected, or expires; approver is the identity sent with the signal
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS approval_decision VARCHAR(16)
+    CHECK (approval_decision IN ('APPROVED', 'REJECTED', 'EXPIRED'));
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS approver VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS approval_comment TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS approval_decided_at TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+-- Connect to appdb and allow users to be blocked from ordering
+\c appdb
+
+-- ValidateUserActivity rejects orders placed by blocked users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,16 @@
+-- Connect to appdb and record which workflow owns each order
+\c appdb
+
+-- Set by UpdateInventoryActivity when it creates the order. Another workflow
+-- submitted with the same idempotency key finds the order owned by someone
+-- else and fails with DuplicateOrder instead of paying for or rolling it back
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS workflow_id VARCHAR(255);
+
+-- Existing orders belong to the workflow that created their first history row
+UPDATE orders o
+SET workflow_id = h.workflow_id
+FROM order_status_history h
+WHERE h.order_id = o.id
+  AND h.from_status IS NULL
+  AND h.workflow_id IS NOT NULL
+  AND o.workflow_id IS NULL;
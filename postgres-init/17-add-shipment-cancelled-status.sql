@@ -0,0 +1,9 @@
+-- Connect to appdb and record cancelled shipments on orders
+\c appdb
+
+-- CancelShipmentActivity moves a SHIPPED order here when the carrier has
+-- cancelled its shipment; the refund and release then roll the rest back
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'SHIPMENT_CANCELLED';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipment_cancelled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipment_cancelled_at TIMESTAMPTZ;
@@ -0,0 +1,24 @@
+-- Connect to appdb and open the ledger of every new product
+\c appdb
+
+-- Products are inserted outside the app (seed data, catalogue imports), so
+-- open their ledger here; later stock changes go through AddProductStock or
+-- `adjust-stock`, which record their own movements
+CREATE OR REPLACE FUNCTION record_product_opening_balance()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO inventory_movements (product_id, delta, reason)
+    VALUES (NEW.id, NEW.items_available, 'OPENING_BALANCE');
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS record_product_opening_balance ON products;
+CREATE TRIGGER record_product_opening_balance AFTER INSERT ON products
+    FOR EACH ROW EXECUTE FUNCTION record_product_opening_balance();
+
+-- Open the ledger of products inserted since 08-create-inventory-movements.sql
+INSERT INTO inventory_movements (product_id, delta, reason)
+SELECT p.id, p.items_available, 'OPENING_BALANCE'
+FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM inventory_movements m WHERE m.product_id = p.id);
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// StockDrift is a product whose items_available does not match the stock
+// recomputed from the inventory ledger.
+type StockDrift struct {
+	ProductID      uuid.UUID
+	ItemsAvailable int
+	LedgerBalance  int
+}
+
+// Drift is the number of items the product count is above (positive) or
+// below (negative) the ledger balance.
+func (d StockDrift) Drift() int {
+	return d.ItemsAvailable - d.LedgerBalance
+}
+
+// ReconcileInventory recomputes the stock of every product from the ledger and
+// returns the products that drifted, ordered by product ID. Products that only
+// appear in the ledger are reported with zero items available. Orders in flight
+// can show up as transient drift, so re-run to confirm a finding.
+func ReconcileInventory(ctx context.Context, inventory InventoryRepository) ([]StockDrift, error) {
+	products, err := inventory.ListProducts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+	balances, err := inventory.LedgerBalances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum inventory ledger: %w", err)
+	}
+
+	var drifts []StockDrift
+	for _, p := range products {
+		if balance := balances[p.ID]; balance != p.ItemsAvailable {
+			drifts = append(drifts, StockDrift{ProductID: p.ID, ItemsAvailable: p.ItemsAvailable, LedgerBalance: balance})
+		}
+		delete(balances, p.ID)
+	}
+	for productID, balance := range balances {
+		if balance != 0 {
+			drifts = append(drifts, StockDrift{ProductID: productID, LedgerBalance: balance})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return bytes.Compare(drifts[i].ProductID[:], drifts[j].ProductID[:]) < 0
+	})
+	return drifts, nil
+}
+
+// AdjustStock changes the stock of a product outside an order, for example for
+// a delivery from a supplier or after a stock count, and records the change in
+// the ledger as an adjustment so that the product stays reconciled.
+func AdjustStock(ctx context.Context, inventory InventoryRepository, productID uuid.UUID, delta int) error {
+	movement := InventoryMovement{ProductID: productID, Delta: delta, Reason: MovementAdjustment}
+	if err := inventory.AddProductStock(ctx, movement); err != nil {
+		return fmt.Errorf("failed to adjust stock of product %s by %+d: %w", productID, delta, err)
+	}
+	return nil
+}
+
+// runReconcile reports stock drift in the app database and exits non-zero when there is any.
+func runReconcile() {
+	cfg := LoadConfigFromEnv()
+	db, err := openDB("postgres", cfg.DBConnectionString())
+	if err != nil {
+		log.Fatalln("Unable to connect to database", err)
+	}
+	defer db.Close()
+
+	drifts, err := ReconcileInventory(context.Background(), NewPostgresStore(db))
+	if err != nil {
+		// log.Fatalln skips deferred calls
+		db.Close()
+		log.Fatalln("Unable to reconcile inventory", err)
+	}
+	if len(drifts) == 0 {
+		log.Println("Inventory matches the ledger")
+		return
+	}
+	for _, d := range drifts {
+		log.Printf("product %s: items_available %d, ledger %d, drift %+d\n", d.ProductID, d.ItemsAvailable, d.LedgerBalance, d.Drift())
+	}
+	db.Close()
+	os.Exit(1)
+}
+
+// runAdjustStock adds the delta given on the command line to a product's stock
+// in the app database.
+func runAdjustStock(args []string) {
+	if len(args) != 2 {
+		log.Fatalln("Usage: adjust-stock <product-id> <delta>")
+	}
+	productID, err := uuid.Parse(args[0])
+	if err != nil {
+		log.Fatalln("Invalid product ID", err)
+	}
+	delta, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Fatalln("Invalid delta", err)
+	}
+
+	cfg := LoadConfigFromEnv()
+	db, err := openDB("postgres", cfg.DBConnectionString())
+	if err != nil {
+		log.Fatalln("Unable to connect to database", err)
+	}
+	defer db.Close()
+
+	if err := AdjustStock(context.Background(), NewPostgresStore(db), productID, delta); err != nil {
+		// log.Fatalln skips deferred calls
+		db.Close()
+		log.Fatalln("Unable to adjust stock", err)
+	}
+	log.Printf("product %s: stock adjusted by %+d\n", productID, delta)
+}
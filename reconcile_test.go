@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReconcileInventory(t *testing.T) {
+	ctx := context.Background()
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	chargerID := uuid.New()
+	ghostID := uuid.New()
+
+	tests := []struct {
+		name  string
+		setup func(*MemoryStore)
+		want  []StockDrift
+	}{
+		{
+			name:  "balanced",
+			setup: func(*MemoryStore) {},
+		},
+		{
+			name: "movements match stock changes",
+			setup: func(m *MemoryStore) {
+				_ = m.AddProductStock(ctx, InventoryMovement{ProductID: phoneID, Delta: -3, Reason: MovementOrderReserved})
+			},
+		},
+		{
+			name: "stock adjusted outside an order",
+			setup: func(m *MemoryStore) {
+				_ = AdjustStock(ctx, m, caseID, 20)
+			},
+		},
+		{
+			name: "product created after the ledger was opened",
+			setup: func(m *MemoryStore) {
+				m.AddProduct(Product{ID: chargerID, ItemsAvailable: 8})
+				_ = AdjustStock(ctx, m, chargerID, -1)
+			},
+		},
+		{
+			name: "stock changed without a movement",
+			setup: func(m *MemoryStore) {
+				m.mu.Lock()
+				m.products[caseID] = Product{ID: caseID, ItemsAvailable: 4}
+				m.mu.Unlock()
+			},
+			want: []StockDrift{{ProductID: caseID, ItemsAvailable: 4, LedgerBalance: 5}},
+		},
+		{
+			name: "movement for an unknown product",
+			setup: func(m *MemoryStore) {
+				m.mu.Lock()
+				m.appendMovement(InventoryMovement{ProductID: ghostID, Delta: 2, Reason: MovementAdjustment})
+				m.mu.Unlock()
+			},
+			want: []StockDrift{{ProductID: ghostID, LedgerBalance: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			store.AddProduct(Product{ID: phoneID, ItemsAvailable: 10})
+			store.AddProduct(Product{ID: caseID, ItemsAvailable: 5})
+			tt.setup(store)
+
+			got, err := ReconcileInventory(ctx, store)
+			if err != nil {
+				t.Fatalf("ReconcileInventory() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ReconcileInventory() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ReconcileInventory()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAdjustStock_RecordsAdjustment(t *testing.T) {
+	store := NewMemoryStore()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 5})
+
+	if err := AdjustStock(context.Background(), store, productID, 3); err != nil {
+		t.Fatalf("AdjustStock() error = %v", err)
+	}
+
+	movements := store.Movements()
+	want := []InventoryMovement{
+		{ProductID: productID, Delta: 5, Reason: MovementOpeningBalance},
+		{ProductID: productID, Delta: 3, Reason: MovementAdjustment},
+	}
+	if len(movements) != len(want) {
+		t.Fatalf("Movements() = %+v, want %+v", movements, want)
+	}
+	for i, m := range movements {
+		if m.ProductID != want[i].ProductID || m.Delta != want[i].Delta || m.Reason != want[i].Reason {
+			t.Errorf("Movements()[%d] = %+v, want %+v", i, m, want[i])
+		}
+	}
+	if err := AdjustStock(context.Background(), store, productID, -9); err == nil {
+		t.Error("AdjustStock(-9) error = nil, want error")
+	}
+}
+
+func TestStockDrift_Drift(t *testing.T) {
+	if got := (StockDrift{ItemsAvailable: 4, LedgerBalance: 5}).Drift(); got != -1 {
+		t.Errorf("Drift() = %d, want -1", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+// replayHistories are workflow histories exported from a Temporal server with
+// `temporal workflow show --output json`, one per path through OrderWorkflow.
+const replayHistories = "testdata/histories/*.json"
+
+// historyEnum matches the enum values of the histories the CLI exports, e.g.
+// "EVENT_TYPE_TIMER_FIRED", which this SDK reads as "TimerFired".
+var historyEnum = regexp.MustCompile(`"(?:EVENT_TYPE|TASK_QUEUE_KIND|RETRY_STATE|TIMEOUT_TYPE|PARENT_CLOSE_POLICY|WORKFLOW_ID_REUSE_POLICY|CONTINUE_AS_NEW_INITIATOR|WORKFLOW_TASK_FAILED_CAUSE|CANCEL_EXTERNAL_WORKFLOW_EXECUTION_FAILED_CAUSE|SIGNAL_EXTERNAL_WORKFLOW_EXECUTION_FAILED_CAUSE|START_CHILD_WORKFLOW_EXECUTION_FAILED_CAUSE|INDEXED_VALUE_TYPE|SEVERITY)_([A-Z0-9_]+)"`)
+
+// loadHistory reads an exported workflow history.
+func loadHistory(t *testing.T, file string) *historypb.History {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", file, err)
+	}
+	data = historyEnum.ReplaceAllFunc(data, func(value []byte) []byte {
+		words := strings.Split(string(historyEnum.FindSubmatch(value)[1]), "_")
+		for i, word := range words {
+			words[i] = word[:1] + strings.ToLower(word[1:])
+		}
+		return []byte(`"` + strings.Join(words, "") + `"`)
+	})
+	history, err := client.HistoryFromJSON(bytes.NewReader(data), client.HistoryJSONOptions{})
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", file, err)
+	}
+	return history
+}
+
+// versionedHistories names, for each versioned change to OrderWorkflow, a
+// history recorded before the change and one recorded after it, which must
+// both keep replaying until the old branch is removed.
+var versionedHistories = []struct {
+	changeID string
+	before   string
+	after    string
+}{
+	{refundCancelledPaymentChange, "order-cancelled-payment-declined", "order-cancelled-during-payment"},
+	{markFailedOrderChange, "order-payment-declined", "order-payment-failed"},
+	{ignoreEarlyApprovalChange, "order-approval-approved", "order-approval-early-approve"},
+}
+
+// changeVersions returns the change IDs of the version markers in a history.
+func changeVersions(t *testing.T, history *historypb.History) []string {
+	t.Helper()
+	var changeIDs []string
+	for _, event := range history.Events {
+		marker := event.GetMarkerRecordedEventAttributes()
+		if event.EventType != enumspb.EVENT_TYPE_MARKER_RECORDED || marker.MarkerName != "Version" {
+			continue
+		}
+		var changeID string
+		if err := converter.GetDefaultDataConverter().FromPayloads(marker.Details["change-id"], &changeID); err != nil {
+			t.Fatalf("failed to decode version marker: %v", err)
+		}
+		changeIDs = append(changeIDs, changeID)
+	}
+	return changeIDs
+}
+
+// TestReplayOrderWorkflowHistories replays every recorded history against the
+// current OrderWorkflow. A failure means the change to the workflow is not
+// deterministic and would break orders still running when the worker is deployed.
+func TestReplayOrderWorkflowHistories(t *testing.T) {
+	files, err := filepath.Glob(replayHistories)
+	if err != nil {
+		t.Fatalf("failed to list histories: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no histories match %s", replayHistories)
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(OrderWorkflow)
+			if err := replayer.ReplayWorkflowHistory(nil, loadHistory(t, file)); err != nil {
+				t.Fatalf("failed to replay %s: %v", file, err)
+			}
+		})
+	}
+}
+
+// TestReplayOrderWorkflowHistories_LogsNothing checks that OrderWorkflow does
+// not log the steps again when a worker replays its history.
+func TestReplayOrderWorkflowHistories_LogsNothing(t *testing.T) {
+	var buf bytes.Buffer
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(OrderWorkflow)
+	if err := replayer.ReplayWorkflowHistory(NewLogger(&buf, "debug"), loadHistory(t, "testdata/histories/order-shipment-rejected.json")); err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	for _, line := range logLines(t, &buf) {
+		if _, ok := line["OrderID"]; ok {
+			encoded, _ := json.Marshal(line)
+			t.Errorf("logged while replaying: %s", encoded)
+		}
+	}
+}
+
+// TestReplayOrderWorkflowHistories_DetectsNonDeterminism checks that replaying
+// fails for a workflow that no longer schedules the recorded activities.
+func TestReplayOrderWorkflowHistories_DetectsNonDeterminism(t *testing.T) {
+	// Starts a timer where every recorded order validates the user
+	changed := func(ctx workflow.Context, request interface{}) error {
+		return workflow.Sleep(ctx, time.Minute)
+	}
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflowWithOptions(changed, workflow.RegisterOptions{Name: "OrderWorkflow"})
+
+	err := replayer.ReplayWorkflowHistory(nil, loadHistory(t, "testdata/histories/order-shipped.json"))
+	if err == nil {
+		t.Fatal("expected replaying a changed workflow to fail")
+	}
+}
+
+// TestReplayOrderWorkflowHistories_BeforeAndAfterVersionedChanges checks that
+// every versioned change is covered by a history from each side of it.
+func TestReplayOrderWorkflowHistories_BeforeAndAfterVersionedChanges(t *testing.T) {
+	for _, tt := range versionedHistories {
+		t.Run(tt.changeID, func(t *testing.T) {
+			for name, want := range map[string]bool{tt.before: false, tt.after: true} {
+				history := loadHistory(t, filepath.Join("testdata", "histories", name+".json"))
+				got := false
+				for _, changeID := range changeVersions(t, history) {
+					got = got || changeID == tt.changeID
+				}
+				if got != want {
+					t.Fatalf("%s has a %s version marker: %v, want %v", name, tt.changeID, got, want)
+				}
+
+				replayer := worker.NewWorkflowReplayer()
+				replayer.RegisterWorkflow(OrderWorkflow)
+				if err := replayer.ReplayWorkflowHistory(nil, history); err != nil {
+					t.Fatalf("failed to replay %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned by repositories when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Product is a row of the products table
+type Product struct {
+	ID             uuid.UUID
+	Type           string
+	ItemsAvailable int
+	Price          model.Money
+}
+
+// Order is a row of the orders table
+type Order struct {
+	ID                     uuid.UUID
+	UserID                 uuid.UUID
+	Items                  []model.OrderItem
+	TotalPrice             model.Money
+	ExchangeRates          []model.ExchangeRate
+	Discounts              []model.Discount
+	Taxes                  []model.TaxLine
+	TaxCalculated          bool
+	Status                 string
+	PaymentAuthorizationID string
+	PaymentCaptureID       string
+	PaymentRefundID        string
+	ShipmentID             string
+	TrackingNumber         string
+	ShippingLabelURL       string
+	InventoryReleased      bool
+	ShipmentCancelled      bool
+	ApprovalDecision       string
+	Approver               string
+	ApprovalComment        string
+	// WorkflowID is the ID of the workflow that created the order and owns it
+	WorkflowID string
+	CreatedAt  time.Time
+}
+
+// Reasons recorded on inventory movements
+const (
+	MovementOpeningBalance = "OPENING_BALANCE"
+	MovementAdjustment     = "ADJUSTMENT"
+	MovementOrderReserved  = "ORDER_RESERVED"
+	MovementOrderReleased  = "ORDER_RELEASED"
+)
+
+// InventoryMovement is a row of the inventory_movements ledger. Delta is
+// negative when stock leaves the warehouse and positive when it comes back.
+type InventoryMovement struct {
+	ID         int64
+	OrderID    uuid.UUID
+	ProductID  uuid.UUID
+	Delta      int
+	Reason     string
+	WorkflowID string
+	RunID      string
+	CreatedAt  time.Time
+}
+
+// OrderStatusChange is a row of the order_status_history table. FromStatus
+// is empty for the row written when the order is created.
+type OrderStatusChange struct {
+	ID         int64
+	OrderID    uuid.UUID
+	FromStatus string
+	ToStatus   string
+	Reason     string
+	WorkflowID string
+	RunID      string
+	CreatedAt  time.Time
+}
+
+// Coupon types
+const (
+	CouponPercentage  = "PERCENTAGE"
+	CouponFixedAmount = "FIXED_AMOUNT"
+	CouponBuyXGetY    = "BUY_X_GET_Y"
+)
+
+// Coupon is a row of the coupons table. Which fields apply depends on Type:
+// PercentOff (a decimal percentage, e.g. "12.5") for PERCENTAGE, AmountOff for
+// FIXED_AMOUNT, and ProductID, BuyQuantity and FreeQuantity for BUY_X_GET_Y.
+// MaxUsesPerUser is the number of orders a user may redeem the coupon on; zero
+// means no limit.
+type Coupon struct {
+	Code           string
+	Type           string
+	PercentOff     string
+	AmountOff      model.Money
+	ProductID      uuid.UUID
+	BuyQuantity    int
+	FreeQuantity   int
+	MaxUsesPerUser int
+	Active         bool
+}
+
+// CouponRedemption is a row of the coupon_redemptions table. A redemption
+// counts towards the user's limit until it is released, which happens when the
+// order is rolled back.
+type CouponRedemption struct {
+	ID         int64
+	CouponCode string
+	UserID     uuid.UUID
+	OrderID    uuid.UUID
+	Amount     model.Money
+	CreatedAt  time.Time
+	ReleasedAt time.Time
+}
+
+// TaxRule is a row of the tax_rules table. A rule applies to addresses in
+// Country and, when set, Region, and to products of ProductType when set.
+// Rate is a decimal fraction, e.g. "0.18".
+type TaxRule struct {
+	ID          int64
+	Name        string
+	Country     string
+	Region      string
+	ProductType string
+	Rate        string
+}
+
+// User is a row of the users table
+type User struct {
+	ID        uuid.UUID
+	Name      string
+	Email     string
+	Address   string
+	Blocked   bool
+	CreatedAt time.Time
+}
+
+// Transactor runs a unit of work atomically.
+type Transactor interface {
+	// WithinTransaction runs fn in a transaction. Repository calls made with the
+	// context passed to fn join the transaction, which is committed when fn
+	// returns nil and rolled back otherwise. Nested calls join the outer transaction.
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// InventoryRepository reads and updates product stock.
+type InventoryRepository interface {
+	// GetProductForUpdate returns the product and, inside a transaction, locks
+	// it until the transaction ends.
+	GetProductForUpdate(ctx context.Context, id uuid.UUID) (Product, error)
+	// AddProductStock adds movement.Delta to the number of items available for
+	// movement.ProductID and appends the movement to the ledger in the same
+	// statement, so stock cannot change without a ledger row.
+	AddProductStock(ctx context.Context, movement InventoryMovement) error
+	// ListProducts returns every product.
+	ListProducts(ctx context.Context) ([]Product, error)
+	// LedgerBalances returns the sum of ledger deltas per product.
+	LedgerBalances(ctx context.Context) (map[uuid.UUID]int, error)
+}
+
+// OrderRepository reads and updates orders.
+type OrderRepository interface {
+	CreateOrder(ctx context.Context, order Order) error
+	GetOrder(ctx context.Context, id uuid.UUID) (Order, error)
+	// GetOrderForUpdate returns the order and, inside a transaction, locks it
+	// until the transaction ends.
+	GetOrderForUpdate(ctx context.Context, id uuid.UUID) (Order, error)
+	// CountOrdersSince returns how many orders the user has placed at or after since.
+	CountOrdersSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error)
+	UpdateOrderStatus(ctx context.Context, id uuid.UUID, status string) error
+	// RecordPayment stores the gateway transaction IDs of a captured payment together with the new status.
+	RecordPayment(ctx context.Context, id uuid.UUID, status, authorizationID, captureID string) error
+	// RecordRefund stores the gateway refund ID together with the new status.
+	RecordRefund(ctx context.Context, id uuid.UUID, status, refundID string) error
+	// MarkInventoryReleased flags the order's reserved stock as returned. It
+	// reports false when the flag was already set, so that callers running in the
+	// same transaction can skip releasing the stock again.
+	MarkInventoryReleased(ctx context.Context, id uuid.UUID) (bool, error)
+	// RecordShipment stores the carrier shipment together with the new status.
+	RecordShipment(ctx context.Context, id uuid.UUID, status string, shipment Shipment) error
+	// RecordShipmentCancellation stores that the carrier cancelled the order's
+	// shipment together with the new status.
+	RecordShipmentCancellation(ctx context.Context, id uuid.UUID, status string) error
+	// RecordApproval stores the outcome of the order's manual approval and who decided it.
+	RecordApproval(ctx context.Context, id uuid.UUID, decision, approver, comment string) error
+	// RecordTax stores the taxes of the order together with its new total. It
+	// reports false, and changes nothing, when the tax was already recorded.
+	RecordTax(ctx context.Context, id uuid.UUID, taxes []model.TaxLine, totalPrice model.Money) (bool, error)
+	// AppendStatusHistory adds a status change to the order's timeline. Call it
+	// in the same transaction as the change itself.
+	AppendStatusHistory(ctx context.Context, change OrderStatusChange) error
+}
+
+// CouponRepository reads coupons and tracks their use.
+type CouponRepository interface {
+	// GetCouponForUpdate returns the coupon and, inside a transaction, locks it
+	// until the transaction ends, so that concurrent orders cannot both take
+	// the last use allowed to a user.
+	GetCouponForUpdate(ctx context.Context, code string) (Coupon, error)
+	// CountRedemptions returns how many unreleased redemptions of the coupon the user has.
+	CountRedemptions(ctx context.Context, code string, userID uuid.UUID) (int, error)
+	// RecordRedemption stores the use of a coupon on an order.
+	RecordRedemption(ctx context.Context, redemption CouponRedemption) error
+	// ReleaseRedemptions releases every coupon redeemed on the order and
+	// returns how many were still held.
+	ReleaseRedemptions(ctx context.Context, orderID uuid.UUID) (int, error)
+}
+
+// TaxRuleRepository reads tax rules.
+type TaxRuleRepository interface {
+	ListTaxRules(ctx context.Context) ([]TaxRule, error)
+}
+
+// UserRepository reads users.
+type UserRepository interface {
+	GetUser(ctx context.Context, id uuid.UUID) (User, error)
+}
+
+// Repositories bundles the storage dependencies of Activities.
+type Repositories struct {
+	Tx        Transactor
+	Orders    OrderRepository
+	Inventory InventoryRepository
+	Users     UserRepository
+	Coupons   CouponRepository
+	TaxRules  TaxRuleRepository
+}
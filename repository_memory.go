@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore implements the repositories in memory, for tests and local runs.
+// Transactions serialise all access to the store and roll back every change
+// made through the transaction context when the unit of work fails.
+type MemoryStore struct {
+	mu          sync.Mutex
+	products    map[uuid.UUID]Product
+	orders      map[uuid.UUID]Order
+	users       map[uuid.UUID]User
+	movements   []InventoryMovement
+	history     []OrderStatusChange
+	coupons     map[string]Coupon
+	redemptions []CouponRedemption
+	taxRules    []TaxRule
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		products: make(map[uuid.UUID]Product),
+		orders:   make(map[uuid.UUID]Order),
+		users:    make(map[uuid.UUID]User),
+		coupons:  make(map[string]Coupon),
+	}
+}
+
+// Repositories returns the store as the full set of repositories.
+func (m *MemoryStore) Repositories() Repositories {
+	return Repositories{Tx: m, Orders: m, Inventory: m, Users: m, Coupons: m, TaxRules: m}
+}
+
+// AddProduct inserts or replaces a product and records its stock in the
+// ledger, as an opening balance for a new product and as an adjustment
+// otherwise, the way the products trigger does in Postgres.
+func (m *MemoryStore) AddProduct(p Product) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.products[p.ID]
+	switch {
+	case !ok:
+		m.appendMovement(InventoryMovement{ProductID: p.ID, Delta: p.ItemsAvailable, Reason: MovementOpeningBalance})
+	case p.ItemsAvailable != existing.ItemsAvailable:
+		m.appendMovement(InventoryMovement{ProductID: p.ID, Delta: p.ItemsAvailable - existing.ItemsAvailable, Reason: MovementAdjustment})
+	}
+	m.products[p.ID] = p
+}
+
+// AddUser inserts or replaces a user.
+func (m *MemoryStore) AddUser(u User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[u.ID] = u
+}
+
+// AddCoupon inserts or replaces a coupon.
+func (m *MemoryStore) AddCoupon(c Coupon) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.coupons[strings.ToUpper(c.Code)] = c
+}
+
+// AddTaxRule appends a tax rule.
+func (m *MemoryStore) AddTaxRule(r TaxRule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = int64(len(m.taxRules) + 1)
+	m.taxRules = append(m.taxRules, r)
+}
+
+type memoryTxKey struct{}
+
+// lock takes the store lock unless ctx belongs to a transaction that already holds it.
+func (m *MemoryStore) lock(ctx context.Context) func() {
+	if ctx.Value(memoryTxKey{}) == m {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+// WithinTransaction implements Transactor.
+func (m *MemoryStore) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) == m {
+		return fn(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	products := copyMap(m.products)
+	orders := copyMap(m.orders)
+	users := copyMap(m.users)
+	coupons := copyMap(m.coupons)
+	movements, history := len(m.movements), len(m.history)
+	// Redemptions are updated in place when released, so keep a full copy
+	redemptions := append([]CouponRedemption(nil), m.redemptions...)
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, m)); err != nil {
+		m.products, m.orders, m.users, m.coupons = products, orders, users, coupons
+		m.movements, m.history = m.movements[:movements], m.history[:history]
+		m.redemptions = redemptions
+		return err
+	}
+	return nil
+}
+
+// GetProductForUpdate implements InventoryRepository.
+func (m *MemoryStore) GetProductForUpdate(ctx context.Context, id uuid.UUID) (Product, error) {
+	defer m.lock(ctx)()
+	p, ok := m.products[id]
+	if !ok {
+		return Product{}, fmt.Errorf("product %s: %w", id, ErrNotFound)
+	}
+	return p, nil
+}
+
+// AddProductStock implements InventoryRepository.
+func (m *MemoryStore) AddProductStock(ctx context.Context, movement InventoryMovement) error {
+	defer m.lock(ctx)()
+	p, ok := m.products[movement.ProductID]
+	if !ok {
+		return fmt.Errorf("product %s: %w", movement.ProductID, ErrNotFound)
+	}
+	if p.ItemsAvailable+movement.Delta < 0 {
+		return fmt.Errorf("product %s: items available cannot be negative", movement.ProductID)
+	}
+	p.ItemsAvailable += movement.Delta
+	m.products[movement.ProductID] = p
+	m.appendMovement(movement)
+	return nil
+}
+
+func (m *MemoryStore) appendMovement(movement InventoryMovement) {
+	movement.ID = int64(len(m.movements) + 1)
+	if movement.CreatedAt.IsZero() {
+		movement.CreatedAt = time.Now().UTC()
+	}
+	m.movements = append(m.movements, movement)
+}
+
+// Movements returns a copy of the ledger in insertion order.
+func (m *MemoryStore) Movements() []InventoryMovement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]InventoryMovement(nil), m.movements...)
+}
+
+// ListProducts implements InventoryRepository.
+func (m *MemoryStore) ListProducts(ctx context.Context) ([]Product, error) {
+	defer m.lock(ctx)()
+	products := make([]Product, 0, len(m.products))
+	for _, p := range m.products {
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+// LedgerBalances implements InventoryRepository.
+func (m *MemoryStore) LedgerBalances(ctx context.Context) (map[uuid.UUID]int, error) {
+	defer m.lock(ctx)()
+	balances := make(map[uuid.UUID]int)
+	for _, movement := range m.movements {
+		balances[movement.ProductID] += movement.Delta
+	}
+	return balances, nil
+}
+
+// CreateOrder implements OrderRepository.
+func (m *MemoryStore) CreateOrder(ctx context.Context, order Order) error {
+	defer m.lock(ctx)()
+	if _, ok := m.orders[order.ID]; ok {
+		return fmt.Errorf("order %s already exists", order.ID)
+	}
+	order.Items = append([]model.OrderItem(nil), order.Items...)
+	order.ExchangeRates = append([]model.ExchangeRate(nil), order.ExchangeRates...)
+	order.Discounts = append([]model.Discount(nil), order.Discounts...)
+	order.Taxes = append([]model.TaxLine(nil), order.Taxes...)
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = time.Now().UTC()
+	}
+	m.orders[order.ID] = order
+	return nil
+}
+
+// GetOrder implements OrderRepository.
+func (m *MemoryStore) GetOrder(ctx context.Context, id uuid.UUID) (Order, error) {
+	defer m.lock(ctx)()
+	order, ok := m.orders[id]
+	if !ok {
+		return Order{}, fmt.Errorf("order %s: %w", id, ErrNotFound)
+	}
+	order.Items = append([]model.OrderItem(nil), order.Items...)
+	order.ExchangeRates = append([]model.ExchangeRate(nil), order.ExchangeRates...)
+	order.Discounts = append([]model.Discount(nil), order.Discounts...)
+	order.Taxes = append([]model.TaxLine(nil), order.Taxes...)
+	return order, nil
+}
+
+// GetOrderForUpdate implements OrderRepository. Transactions already hold the
+// store lock, so this is the same as GetOrder.
+func (m *MemoryStore) GetOrderForUpdate(ctx context.Context, id uuid.UUID) (Order, error) {
+	return m.GetOrder(ctx, id)
+}
+
+// CountOrdersSince implements OrderRepository.
+func (m *MemoryStore) CountOrdersSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	defer m.lock(ctx)()
+	var n int
+	for _, order := range m.orders {
+		if order.UserID == userID && !order.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// UpdateOrderStatus implements OrderRepository.
+func (m *MemoryStore) UpdateOrderStatus(ctx context.Context, id uuid.UUID, status string) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.Status = status
+	})
+}
+
+// RecordPayment implements OrderRepository.
+func (m *MemoryStore) RecordPayment(ctx context.Context, id uuid.UUID, status, authorizationID, captureID string) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.Status = status
+		o.PaymentAuthorizationID = authorizationID
+		o.PaymentCaptureID = captureID
+	})
+}
+
+// RecordRefund implements OrderRepository.
+func (m *MemoryStore) RecordRefund(ctx context.Context, id uuid.UUID, status, refundID string) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.Status = status
+		o.PaymentRefundID = refundID
+	})
+}
+
+// MarkInventoryReleased implements OrderRepository.
+func (m *MemoryStore) MarkInventoryReleased(ctx context.Context, id uuid.UUID) (bool, error) {
+	var marked bool
+	err := m.updateOrder(ctx, id, func(o *Order) {
+		marked = !o.InventoryReleased
+		o.InventoryReleased = true
+	})
+	return marked, err
+}
+
+// RecordTax implements OrderRepository.
+func (m *MemoryStore) RecordTax(ctx context.Context, id uuid.UUID, taxes []model.TaxLine, totalPrice model.Money) (bool, error) {
+	var recorded bool
+	err := m.updateOrder(ctx, id, func(o *Order) {
+		if o.TaxCalculated {
+			return
+		}
+		o.Taxes = append([]model.TaxLine(nil), taxes...)
+		o.TotalPrice = totalPrice
+		o.TaxCalculated = true
+		recorded = true
+	})
+	return recorded, err
+}
+
+// RecordShipment implements OrderRepository.
+func (m *MemoryStore) RecordShipment(ctx context.Context, id uuid.UUID, status string, shipment Shipment) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.Status = status
+		o.ShipmentID = shipment.ShipmentID
+		o.TrackingNumber = shipment.TrackingNumber
+		o.ShippingLabelURL = shipment.LabelURL
+	})
+}
+
+// RecordShipmentCancellation implements OrderRepository.
+func (m *MemoryStore) RecordShipmentCancellation(ctx context.Context, id uuid.UUID, status string) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.Status = status
+		o.ShipmentCancelled = true
+	})
+}
+
+// RecordApproval implements OrderRepository.
+func (m *MemoryStore) RecordApproval(ctx context.Context, id uuid.UUID, decision, approver, comment string) error {
+	return m.updateOrder(ctx, id, func(o *Order) {
+		o.ApprovalDecision = decision
+		o.Approver = approver
+		o.ApprovalComment = comment
+	})
+}
+
+// AppendStatusHistory implements OrderRepository.
+func (m *MemoryStore) AppendStatusHistory(ctx context.Context, change OrderStatusChange) error {
+	defer m.lock(ctx)()
+	if _, ok := m.orders[change.OrderID]; !ok {
+		return fmt.Errorf("order %s: %w", change.OrderID, ErrNotFound)
+	}
+	change.ID = int64(len(m.history) + 1)
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now().UTC()
+	}
+	m.history = append(m.history, change)
+	return nil
+}
+
+// StatusHistory returns the timeline of the order, oldest change first.
+func (m *MemoryStore) StatusHistory(id uuid.UUID) []OrderStatusChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var history []OrderStatusChange
+	for _, change := range m.history {
+		if change.OrderID == id {
+			history = append(history, change)
+		}
+	}
+	return history
+}
+
+func (m *MemoryStore) updateOrder(ctx context.Context, id uuid.UUID, update func(*Order)) error {
+	defer m.lock(ctx)()
+	order, ok := m.orders[id]
+	if !ok {
+		return fmt.Errorf("order %s: %w", id, ErrNotFound)
+	}
+	update(&order)
+	m.orders[id] = order
+	return nil
+}
+
+// ListTaxRules implements TaxRuleRepository.
+func (m *MemoryStore) ListTaxRules(ctx context.Context) ([]TaxRule, error) {
+	defer m.lock(ctx)()
+	return append([]TaxRule(nil), m.taxRules...), nil
+}
+
+// GetUser implements UserRepository.
+func (m *MemoryStore) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	defer m.lock(ctx)()
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	return u, nil
+}
+
+// GetCouponForUpdate implements CouponRepository.
+func (m *MemoryStore) GetCouponForUpdate(ctx context.Context, code string) (Coupon, error) {
+	defer m.lock(ctx)()
+	c, ok := m.coupons[strings.ToUpper(code)]
+	if !ok {
+		return Coupon{}, fmt.Errorf("coupon %s: %w", code, ErrNotFound)
+	}
+	return c, nil
+}
+
+// CountRedemptions implements CouponRepository.
+func (m *MemoryStore) CountRedemptions(ctx context.Context, code string, userID uuid.UUID) (int, error) {
+	defer m.lock(ctx)()
+	var n int
+	for _, r := range m.redemptions {
+		if strings.EqualFold(r.CouponCode, code) && r.UserID == userID && r.ReleasedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RecordRedemption implements CouponRepository.
+func (m *MemoryStore) RecordRedemption(ctx context.Context, redemption CouponRedemption) error {
+	defer m.lock(ctx)()
+	if _, ok := m.orders[redemption.OrderID]; !ok {
+		return fmt.Errorf("order %s: %w", redemption.OrderID, ErrNotFound)
+	}
+	redemption.ID = int64(len(m.redemptions) + 1)
+	if redemption.CreatedAt.IsZero() {
+		redemption.CreatedAt = time.Now().UTC()
+	}
+	m.redemptions = append(m.redemptions, redemption)
+	return nil
+}
+
+// ReleaseRedemptions implements CouponRepository.
+func (m *MemoryStore) ReleaseRedemptions(ctx context.Context, orderID uuid.UUID) (int, error) {
+	defer m.lock(ctx)()
+	var n int
+	for i, r := range m.redemptions {
+		if r.OrderID == orderID && r.ReleasedAt.IsZero() {
+			m.redemptions[i].ReleasedAt = time.Now().UTC()
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Redemptions returns a copy of the coupon redemptions in insertion order.
+func (m *MemoryStore) Redemptions() []CouponRedemption {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]CouponRedemption(nil), m.redemptions...)
+}
+
+func copyMap[K comparable, V any](src map[K]V) map[K]V {
+	dst := make(map[K]V, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
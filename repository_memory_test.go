@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"sktemporal/model"
+)
+
+func TestMemoryStore_WithinTransaction_RollsBackOnError(t *testing.T) {
+	store := NewMemoryStore()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 5, Price: inr(1000)})
+	orderID := uuid.New()
+
+	fnErr := errors.New("later step failed")
+	err := store.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		if err := store.AddProductStock(ctx, InventoryMovement{ProductID: productID, Delta: -3, Reason: MovementOrderReserved}); err != nil {
+			return err
+		}
+		if err := store.CreateOrder(ctx, Order{ID: orderID, Items: []model.OrderItem{{ProductID: productID, Quantity: 3}}}); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Fatalf("WithinTransaction() error = %v, want %v", err, fnErr)
+	}
+
+	product, err := store.GetProductForUpdate(context.Background(), productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.ItemsAvailable != 5 {
+		t.Errorf("ItemsAvailable = %d after rollback, want 5", product.ItemsAvailable)
+	}
+	if _, err := store.GetOrder(context.Background(), orderID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOrder() error = %v after rollback, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStore_WithinTransaction_CommitsOnSuccess(t *testing.T) {
+	store := NewMemoryStore()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 5, Price: inr(1000)})
+
+	err := store.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		return store.WithinTransaction(ctx, func(ctx context.Context) error {
+			return store.AddProductStock(ctx, InventoryMovement{ProductID: productID, Delta: 2, Reason: MovementAdjustment})
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction() error = %v", err)
+	}
+
+	product, _ := store.GetProductForUpdate(context.Background(), productID)
+	if product.ItemsAvailable != 7 {
+		t.Errorf("ItemsAvailable = %d, want 7", product.ItemsAvailable)
+	}
+}
+
+func TestMemoryStore_RejectsNegativeStock(t *testing.T) {
+	store := NewMemoryStore()
+	productID := uuid.New()
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 1})
+
+	if err := store.AddProductStock(context.Background(), InventoryMovement{ProductID: productID, Delta: -2}); err == nil {
+		t.Error("AddProductStock(-2) error = nil, want error")
+	}
+	if got := len(store.Movements()); got != 1 {
+		t.Errorf("len(Movements()) = %d after rejected change, want 1", got)
+	}
+}
+
+func TestMemoryStore_GetOrder_ReturnsCopyOfItems(t *testing.T) {
+	store := NewMemoryStore()
+	order := Order{ID: uuid.New(), Items: []model.OrderItem{{ProductID: uuid.New(), Quantity: 1}}}
+	if err := store.CreateOrder(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+	order.Items[0].Quantity = 99
+
+	got, err := store.GetOrder(context.Background(), order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Items[0].Quantity != 1 {
+		t.Errorf("stored quantity = %d, want 1", got.Items[0].Quantity)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// PostgresStore implements the repositories on the app database.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a PostgresStore using the given connection pool.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Repositories returns the store as the full set of repositories.
+func (s *PostgresStore) Repositories() Repositories {
+	return Repositories{Tx: s, Orders: s, Inventory: s, Users: s, Coupons: s, TaxRules: s}
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by the store.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type postgresTxKey struct{}
+
+// conn returns the transaction carried by ctx, or the pool when there is none.
+func (s *PostgresStore) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(postgresTxKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// WithinTransaction implements Transactor.
+func (s *PostgresStore) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(postgresTxKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, postgresTxKey{}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetProductForUpdate implements InventoryRepository.
+func (s *PostgresStore) GetProductForUpdate(ctx context.Context, id uuid.UUID) (Product, error) {
+	product := Product{ID: id}
+	var price, currency string
+	err := s.conn(ctx).QueryRowContext(ctx,
+		"SELECT type, items_available, price, currency FROM products WHERE id = $1 FOR UPDATE",
+		id,
+	).Scan(&product.Type, &product.ItemsAvailable, &price, &currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Product{}, fmt.Errorf("product %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	if product.Price, err = scanMoney(price, currency); err != nil {
+		return Product{}, err
+	}
+	return product, nil
+}
+
+// AddProductStock implements InventoryRepository.
+func (s *PostgresStore) AddProductStock(ctx context.Context, movement InventoryMovement) error {
+	var orderID uuid.NullUUID
+	if movement.OrderID != uuid.Nil {
+		orderID = uuid.NullUUID{UUID: movement.OrderID, Valid: true}
+	}
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`WITH updated AS (
+			UPDATE products SET items_available = items_available + $3 WHERE id = $2 RETURNING id
+		 )
+		 INSERT INTO inventory_movements (order_id, product_id, delta, reason, workflow_id, run_id)
+		 SELECT $1, id, $3, $4, $5, $6 FROM updated`,
+		orderID,
+		movement.ProductID,
+		movement.Delta,
+		movement.Reason,
+		movement.WorkflowID,
+		movement.RunID,
+	)
+	return checkAffected(result, err, "product", movement.ProductID)
+}
+
+// ListProducts implements InventoryRepository.
+func (s *PostgresStore) ListProducts(ctx context.Context) ([]Product, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx, "SELECT id, items_available, price, currency FROM products ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []Product
+	for rows.Next() {
+		var p Product
+		var price, currency string
+		if err := rows.Scan(&p.ID, &p.ItemsAvailable, &price, &currency); err != nil {
+			return nil, err
+		}
+		if p.Price, err = scanMoney(price, currency); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// LedgerBalances implements InventoryRepository.
+func (s *PostgresStore) LedgerBalances(ctx context.Context) (map[uuid.UUID]int, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		"SELECT product_id, SUM(delta) FROM inventory_movements GROUP BY product_id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var productID uuid.UUID
+		var balance int
+		if err := rows.Scan(&productID, &balance); err != nil {
+			return nil, err
+		}
+		balances[productID] = balance
+	}
+	return balances, rows.Err()
+}
+
+// CreateOrder implements OrderRepository.
+func (s *PostgresStore) CreateOrder(ctx context.Context, order Order) error {
+	productsJSON, err := json.Marshal(order.Items)
+	if err != nil {
+		return fmt.Errorf("failed to encode order items: %w", err)
+	}
+	ratesJSON, err := json.Marshal(append([]model.ExchangeRate{}, order.ExchangeRates...))
+	if err != nil {
+		return fmt.Errorf("failed to encode exchange rates: %w", err)
+	}
+	discountsJSON, err := json.Marshal(append([]model.Discount{}, order.Discounts...))
+	if err != nil {
+		return fmt.Errorf("failed to encode discounts: %w", err)
+	}
+	_, err = s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO orders (id, userID, products, total_price, currency, exchange_rates, discounts, status, workflow_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		order.ID,
+		order.UserID,
+		productsJSON,
+		order.TotalPrice.Decimal(),
+		order.TotalPrice.Currency,
+		ratesJSON,
+		discountsJSON,
+		order.Status,
+		order.WorkflowID,
+	)
+	return err
+}
+
+// GetOrder implements OrderRepository.
+func (s *PostgresStore) GetOrder(ctx context.Context, id uuid.UUID) (Order, error) {
+	return s.getOrder(ctx, id, "")
+}
+
+// GetOrderForUpdate implements OrderRepository.
+func (s *PostgresStore) GetOrderForUpdate(ctx context.Context, id uuid.UUID) (Order, error) {
+	return s.getOrder(ctx, id, " FOR UPDATE")
+}
+
+func (s *PostgresStore) getOrder(ctx context.Context, id uuid.UUID, lock string) (Order, error) {
+	order := Order{ID: id}
+	var productsJSON, ratesJSON, discountsJSON, taxesJSON []byte
+	var totalPrice, currency string
+	var authorizationID, captureID, refundID sql.NullString
+	var shipmentID, trackingNumber, labelURL sql.NullString
+	var approvalDecision, approver, approvalComment sql.NullString
+	var workflowID sql.NullString
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT userID, products, total_price, currency, exchange_rates, discounts, taxes, tax_calculated, status,
+		        payment_authorization_id, payment_capture_id, payment_refund_id,
+		        shipment_id, tracking_number, shipping_label_url, inventory_released, shipment_cancelled,
+		        approval_decision, approver, approval_comment, workflow_id, created_at
+		 FROM orders WHERE id = $1`+lock,
+		id,
+	).Scan(
+		&order.UserID, &productsJSON, &totalPrice, &currency, &ratesJSON, &discountsJSON, &taxesJSON, &order.TaxCalculated, &order.Status,
+		&authorizationID, &captureID, &refundID,
+		&shipmentID, &trackingNumber, &labelURL, &order.InventoryReleased, &order.ShipmentCancelled,
+		&approvalDecision, &approver, &approvalComment, &workflowID, &order.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, fmt.Errorf("order %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return Order{}, err
+	}
+	if err := json.Unmarshal(productsJSON, &order.Items); err != nil {
+		return Order{}, fmt.Errorf("failed to decode order items: %w", err)
+	}
+	if err := json.Unmarshal(ratesJSON, &order.ExchangeRates); err != nil {
+		return Order{}, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+	if err := json.Unmarshal(discountsJSON, &order.Discounts); err != nil {
+		return Order{}, fmt.Errorf("failed to decode discounts: %w", err)
+	}
+	if err := json.Unmarshal(taxesJSON, &order.Taxes); err != nil {
+		return Order{}, fmt.Errorf("failed to decode taxes: %w", err)
+	}
+	if order.TotalPrice, err = scanMoney(totalPrice, currency); err != nil {
+		return Order{}, err
+	}
+	order.PaymentAuthorizationID = authorizationID.String
+	order.PaymentCaptureID = captureID.String
+	order.PaymentRefundID = refundID.String
+	order.ShipmentID = shipmentID.String
+	order.TrackingNumber = trackingNumber.String
+	order.ShippingLabelURL = labelURL.String
+	order.ApprovalDecision = approvalDecision.String
+	order.Approver = approver.String
+	order.ApprovalComment = approvalComment.String
+	order.WorkflowID = workflowID.String
+	return order, nil
+}
+
+// CountOrdersSince implements OrderRepository.
+func (s *PostgresStore) CountOrdersSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	var n int
+	err := s.conn(ctx).QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM orders WHERE userID = $1 AND created_at >= $2",
+		userID,
+		since,
+	).Scan(&n)
+	return n, err
+}
+
+// UpdateOrderStatus implements OrderRepository.
+func (s *PostgresStore) UpdateOrderStatus(ctx context.Context, id uuid.UUID, status string) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders SET status = $1 WHERE id = $2`,
+		status,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// RecordPayment implements OrderRepository.
+func (s *PostgresStore) RecordPayment(ctx context.Context, id uuid.UUID, status, authorizationID, captureID string) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders
+		 SET status = $1, payment_authorization_id = $2, payment_capture_id = $3
+		 WHERE id = $4`,
+		status,
+		authorizationID,
+		captureID,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// RecordRefund implements OrderRepository.
+func (s *PostgresStore) RecordRefund(ctx context.Context, id uuid.UUID, status, refundID string) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders SET status = $1, payment_refund_id = $2 WHERE id = $3`,
+		status,
+		refundID,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// MarkInventoryReleased implements OrderRepository. The conditional UPDATE
+// locks the row, so concurrent callers cannot both see the flag unset.
+func (s *PostgresStore) MarkInventoryReleased(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders SET inventory_released = TRUE WHERE id = $1 AND NOT inventory_released`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	// Nothing updated: either already released or there is no such order
+	if _, err := s.GetOrder(ctx, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// RecordTax implements OrderRepository. Like MarkInventoryReleased, the
+// conditional UPDATE makes concurrent callers record the tax only once.
+func (s *PostgresStore) RecordTax(ctx context.Context, id uuid.UUID, taxes []model.TaxLine, totalPrice model.Money) (bool, error) {
+	taxesJSON, err := json.Marshal(append([]model.TaxLine{}, taxes...))
+	if err != nil {
+		return false, fmt.Errorf("failed to encode taxes: %w", err)
+	}
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders SET taxes = $1, total_price = $2, tax_calculated = TRUE
+		 WHERE id = $3 AND NOT tax_calculated`,
+		taxesJSON,
+		totalPrice.Decimal(),
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	// Nothing updated: either already recorded or there is no such order
+	if _, err := s.GetOrder(ctx, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// RecordShipment implements OrderRepository.
+func (s *PostgresStore) RecordShipment(ctx context.Context, id uuid.UUID, status string, shipment Shipment) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders
+		 SET status = $1, shipment_id = $2, tracking_number = $3, shipping_label_url = $4
+		 WHERE id = $5`,
+		status,
+		shipment.ShipmentID,
+		shipment.TrackingNumber,
+		shipment.LabelURL,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// RecordShipmentCancellation implements OrderRepository.
+func (s *PostgresStore) RecordShipmentCancellation(ctx context.Context, id uuid.UUID, status string) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders
+		 SET status = $1, shipment_cancelled = TRUE, shipment_cancelled_at = CURRENT_TIMESTAMP
+		 WHERE id = $2`,
+		status,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// RecordApproval implements OrderRepository.
+func (s *PostgresStore) RecordApproval(ctx context.Context, id uuid.UUID, decision, approver, comment string) error {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE orders
+		 SET approval_decision = $1, approver = $2, approval_comment = $3, approval_decided_at = CURRENT_TIMESTAMP
+		 WHERE id = $4`,
+		decision,
+		approver,
+		comment,
+		id,
+	)
+	return checkAffected(result, err, "order", id)
+}
+
+// AppendStatusHistory implements OrderRepository.
+func (s *PostgresStore) AppendStatusHistory(ctx context.Context, change OrderStatusChange) error {
+	var fromStatus sql.NullString
+	if change.FromStatus != "" {
+		fromStatus = sql.NullString{String: change.FromStatus, Valid: true}
+	}
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO order_status_history (order_id, from_status, to_status, reason, workflow_id, run_id)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		change.OrderID,
+		fromStatus,
+		change.ToStatus,
+		change.Reason,
+		change.WorkflowID,
+		change.RunID,
+	)
+	return err
+}
+
+// ListTaxRules implements TaxRuleRepository.
+func (s *PostgresStore) ListTaxRules(ctx context.Context) ([]TaxRule, error) {
+	rows, err := s.conn(ctx).QueryContext(ctx,
+		"SELECT id, name, country, region, product_type, rate FROM tax_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []TaxRule
+	for rows.Next() {
+		var r TaxRule
+		var region, productType sql.NullString
+		if err := rows.Scan(&r.ID, &r.Name, &r.Country, &region, &productType, &r.Rate); err != nil {
+			return nil, err
+		}
+		r.Region = region.String
+		r.ProductType = productType.String
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// GetUser implements UserRepository.
+func (s *PostgresStore) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	user := User{ID: id}
+	var address sql.NullString
+	err := s.conn(ctx).QueryRowContext(ctx,
+		"SELECT name, email, address, blocked, created_at FROM users WHERE id = $1",
+		id,
+	).Scan(&user.Name, &user.Email, &address, &user.Blocked, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, fmt.Errorf("user %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user.Address = address.String
+	return user, nil
+}
+
+// GetCouponForUpdate implements CouponRepository.
+func (s *PostgresStore) GetCouponForUpdate(ctx context.Context, code string) (Coupon, error) {
+	coupon := Coupon{Code: code}
+	var percentOff, amountOff, currency sql.NullString
+	var productID uuid.NullUUID
+	var buyQuantity, freeQuantity sql.NullInt64
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT type, percent_off, amount_off, currency, product_id, buy_quantity, free_quantity,
+		        max_uses_per_user, active
+		 FROM coupons WHERE code = UPPER($1) FOR UPDATE`,
+		code,
+	).Scan(
+		&coupon.Type, &percentOff, &amountOff, &currency, &productID, &buyQuantity, &freeQuantity,
+		&coupon.MaxUsesPerUser, &coupon.Active,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Coupon{}, fmt.Errorf("coupon %s: %w", code, ErrNotFound)
+	}
+	if err != nil {
+		return Coupon{}, err
+	}
+	coupon.PercentOff = percentOff.String
+	if amountOff.Valid {
+		if coupon.AmountOff, err = scanMoney(amountOff.String, currency.String); err != nil {
+			return Coupon{}, err
+		}
+	}
+	coupon.ProductID = productID.UUID
+	coupon.BuyQuantity = int(buyQuantity.Int64)
+	coupon.FreeQuantity = int(freeQuantity.Int64)
+	return coupon, nil
+}
+
+// CountRedemptions implements CouponRepository.
+func (s *PostgresStore) CountRedemptions(ctx context.Context, code string, userID uuid.UUID) (int, error) {
+	var n int
+	err := s.conn(ctx).QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM coupon_redemptions
+		 WHERE coupon_code = UPPER($1) AND user_id = $2 AND released_at IS NULL`,
+		code,
+		userID,
+	).Scan(&n)
+	return n, err
+}
+
+// RecordRedemption implements CouponRepository.
+func (s *PostgresStore) RecordRedemption(ctx context.Context, redemption CouponRedemption) error {
+	_, err := s.conn(ctx).ExecContext(ctx,
+		`INSERT INTO coupon_redemptions (coupon_code, user_id, order_id, amount, currency)
+		 VALUES (UPPER($1), $2, $3, $4, $5)`,
+		redemption.CouponCode,
+		redemption.UserID,
+		redemption.OrderID,
+		redemption.Amount.Decimal(),
+		redemption.Amount.Currency,
+	)
+	return err
+}
+
+// ReleaseRedemptions implements CouponRepository.
+func (s *PostgresStore) ReleaseRedemptions(ctx context.Context, orderID uuid.UUID) (int, error) {
+	result, err := s.conn(ctx).ExecContext(ctx,
+		`UPDATE coupon_redemptions SET released_at = CURRENT_TIMESTAMP
+		 WHERE order_id = $1 AND released_at IS NULL`,
+		orderID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// scanMoney converts a DECIMAL column value and its currency column into Money.
+func scanMoney(decimal, currency string) (model.Money, error) {
+	m, err := model.ParseMoney(decimal, strings.TrimSpace(currency))
+	if err != nil {
+		return model.Money{}, fmt.Errorf("failed to decode amount: %w", err)
+	}
+	return m, nil
+}
+
+// checkAffected turns an UPDATE that matched no row into ErrNotFound.
+func checkAffected(result sql.Result, err error, table string, id uuid.UUID) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s %s: %w", table, id, ErrNotFound)
+	}
+	return nil
+}
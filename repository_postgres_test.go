@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"sktemporal/model"
+)
+
+const (
+	addProductStockQuery      = "UPDATE products SET items_available = items_available \\+ \\$3 WHERE id = \\$2.*INSERT INTO inventory_movements \\(order_id, product_id, delta, reason, workflow_id, run_id\\)"
+	selectProductQuery        = "SELECT type, items_available, price, currency FROM products WHERE id = \\$1 FOR UPDATE"
+	insertOrderQuery          = "INSERT INTO orders \\(id, userID, products, total_price, currency, exchange_rates, discounts, status, workflow_id\\)"
+	selectOrderQuery          = "SELECT userID, products, total_price, currency, exchange_rates, discounts, taxes, tax_calculated, status,.*FROM orders WHERE id = \\$1"
+	updateOrderPaymentQuery   = "UPDATE orders.*SET status = \\$1, payment_authorization_id = \\$2, payment_capture_id = \\$3.*WHERE id = \\$4"
+	updateOrderRefundQuery    = "UPDATE orders SET status = \\$1, payment_refund_id = \\$2 WHERE id = \\$3"
+	updateOrderStatusQuery    = "UPDATE orders SET status = \\$1 WHERE id = \\$2"
+	countOrdersSinceQuery     = "SELECT COUNT\\(\\*\\) FROM orders WHERE userID = \\$1 AND created_at >= \\$2"
+	selectUserQuery           = "SELECT name, email, address, blocked, created_at FROM users WHERE id = \\$1"
+	markReleasedQuery         = "UPDATE orders SET inventory_released = TRUE WHERE id = \\$1 AND NOT inventory_released"
+	ledgerBalancesQuery       = "SELECT product_id, SUM\\(delta\\) FROM inventory_movements GROUP BY product_id"
+	selectOrderForUpdateQuery = "SELECT userID, products, total_price, currency, exchange_rates, discounts, taxes, tax_calculated, status,.*FROM orders WHERE id = \\$1 FOR UPDATE"
+	insertStatusHistoryQuery  = "INSERT INTO order_status_history \\(order_id, from_status, to_status, reason, workflow_id, run_id\\)"
+	recordTaxQuery            = "UPDATE orders SET taxes = \\$1, total_price = \\$2, tax_calculated = TRUE.*WHERE id = \\$3 AND NOT tax_calculated"
+	listTaxRulesQuery         = "SELECT id, name, country, region, product_type, rate FROM tax_rules ORDER BY id"
+	selectCouponQuery         = "SELECT type, percent_off, amount_off, currency, product_id, buy_quantity, free_quantity,.*FROM coupons WHERE code = UPPER\\(\\$1\\) FOR UPDATE"
+	releaseRedemptionsQuery   = "UPDATE coupon_redemptions SET released_at = CURRENT_TIMESTAMP.*WHERE order_id = \\$1 AND released_at IS NULL"
+	recordApprovalQuery       = "UPDATE orders.*SET approval_decision = \\$1, approver = \\$2, approval_comment = \\$3.*WHERE id = \\$4"
+	updateOrderShipmentQuery  = "UPDATE orders.*SET status = \\$1, shipment_id = \\$2, tracking_number = \\$3, shipping_label_url = \\$4.*WHERE id = \\$5"
+	cancelShipmentQuery       = "UPDATE orders.*SET status = \\$1, shipment_cancelled = TRUE, shipment_cancelled_at = CURRENT_TIMESTAMP.*WHERE id = \\$2"
+)
+
+var orderColumns = []string{
+	"userID", "products", "total_price", "currency", "exchange_rates", "discounts", "taxes", "tax_calculated", "status",
+	"payment_authorization_id", "payment_capture_id", "payment_refund_id",
+	"shipment_id", "tracking_number", "shipping_label_url", "inventory_released", "shipment_cancelled",
+	"approval_decision", "approver", "approval_comment", "workflow_id", "created_at",
+}
+
+func newPostgresStore(t *testing.T) (*PostgresStore, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return NewPostgresStore(db), mock
+}
+
+func TestPostgresStore_WithinTransaction_CommitsOnSuccess(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	productID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectProductQuery).
+		WithArgs(productID).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "items_available", "price", "currency"}).AddRow("ELECTRONICS", 10, "100.00", "INR"))
+	mock.ExpectExec(addProductStockQuery).
+		WithArgs(uuid.NullUUID{}, productID, -2, MovementAdjustment, "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := store.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		if _, err := store.GetProductForUpdate(ctx, productID); err != nil {
+			return err
+		}
+		return store.AddProductStock(ctx, InventoryMovement{ProductID: productID, Delta: -2, Reason: MovementAdjustment})
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction() error = %v", err)
+	}
+}
+
+func TestPostgresStore_WithinTransaction_RollsBackOnError(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	productID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(addProductStockQuery).
+		WithArgs(uuid.NullUUID{}, productID, 3, MovementAdjustment, "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	fnErr := errors.New("later step failed")
+	err := store.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		if err := store.AddProductStock(ctx, InventoryMovement{ProductID: productID, Delta: 3, Reason: MovementAdjustment}); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Fatalf("WithinTransaction() error = %v, want %v", err, fnErr)
+	}
+}
+
+func TestPostgresStore_WithinTransaction_NestedJoinsOuter(t *testing.T) {
+	store, mock := newPostgresStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := store.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		return store.WithinTransaction(ctx, func(context.Context) error { return nil })
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction() error = %v", err)
+	}
+}
+
+func TestPostgresStore_WithinTransaction_BeginFailure(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+	called := false
+	err := store.WithinTransaction(context.Background(), func(context.Context) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Fatalf("WithinTransaction() error = %v, called = %v; want error and fn not called", err, called)
+	}
+}
+
+func TestPostgresStore_WithinTransaction_CommitFailure(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	mock.ExpectBegin()
+	mock.ExpectCommit().WillReturnError(errors.New("serialization failure"))
+
+	err := store.WithinTransaction(context.Background(), func(context.Context) error { return nil })
+	if err == nil {
+		t.Fatal("WithinTransaction() error = nil, want commit error")
+	}
+}
+
+func TestPostgresStore_GetProductForUpdate_NotFound(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	productID := uuid.New()
+	mock.ExpectQuery(selectProductQuery).WithArgs(productID).WillReturnError(sql.ErrNoRows)
+
+	_, err := store.GetProductForUpdate(context.Background(), productID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetProductForUpdate() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_UpdateNoRows_ReturnsNotFound(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	mock.ExpectExec(updateOrderStatusQuery).
+		WithArgs("CANCELLED", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := store.UpdateOrderStatus(context.Background(), orderID, "CANCELLED")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("UpdateOrderStatus() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_CreateOrder_StoresItemsAsJSON(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	order := Order{
+		ID:         uuid.New(),
+		UserID:     uuid.New(),
+		Items:      []model.OrderItem{{ProductID: uuid.New(), Quantity: 2, UnitPrice: inr(10000)}},
+		TotalPrice: inr(20000),
+		ExchangeRates: []model.ExchangeRate{
+			{From: "USD", To: "INR", Rate: "83.2", AsOf: "2026-10-01"},
+		},
+		Status:     "ADDED_TO_CART",
+		WorkflowID: "order-workflow-1",
+	}
+	productsJSON, err := json.Marshal(order.Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratesJSON, err := json.Marshal(order.ExchangeRates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectExec(insertOrderQuery).
+		WithArgs(order.ID, order.UserID, productsJSON, "200.00", "INR", ratesJSON, []byte("[]"), order.Status, order.WorkflowID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.CreateOrder(context.Background(), order); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+}
+
+func TestPostgresStore_GetOrder(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	userID := uuid.New()
+	items := []model.OrderItem{{ProductID: uuid.New(), Quantity: 1, UnitPrice: inr(9950)}}
+	productsJSON, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(selectOrderQuery).
+		WithArgs(orderID).
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(userID, productsJSON, "99.50", "INR", []byte("[]"), []byte("[]"), []byte("[]"), false, "SHIPPING_INITIATED", "auth-1", "capture-auth-1", nil, nil, nil, nil, false, false, "APPROVED", "risk@example.com", nil, "order-workflow-1", time.Now()))
+
+	order, err := store.GetOrder(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if order.UserID != userID || order.TotalPrice != inr(9950) || order.Status != "SHIPPING_INITIATED" {
+		t.Errorf("GetOrder() = %+v", order)
+	}
+	if len(order.Items) != 1 || order.Items[0] != items[0] {
+		t.Errorf("GetOrder() items = %+v, want %+v", order.Items, items)
+	}
+	if order.PaymentCaptureID != "capture-auth-1" || order.PaymentRefundID != "" {
+		t.Errorf("GetOrder() payment IDs = %q/%q", order.PaymentCaptureID, order.PaymentRefundID)
+	}
+	if order.ApprovalDecision != ApprovalApproved || order.Approver != "risk@example.com" || order.ApprovalComment != "" {
+		t.Errorf("GetOrder() approval = %q/%q/%q", order.ApprovalDecision, order.Approver, order.ApprovalComment)
+	}
+	if order.WorkflowID != "order-workflow-1" {
+		t.Errorf("GetOrder() workflow ID = %q", order.WorkflowID)
+	}
+}
+
+func TestPostgresStore_GetOrder_NotFound(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	mock.ExpectQuery(selectOrderQuery).WithArgs(orderID).WillReturnError(sql.ErrNoRows)
+
+	_, err := store.GetOrder(context.Background(), orderID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetOrder() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_RecordPaymentRefundShipment(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	ctx := context.Background()
+	orderID := uuid.New()
+	shipment := Shipment{ShipmentID: "shipment-1", TrackingNumber: "FAKE0000000001", LabelURL: "https://carrier.invalid/labels/shipment-1.pdf"}
+
+	mock.ExpectExec(updateOrderPaymentQuery).
+		WithArgs("SHIPPING_INITIATED", "auth-1", "capture-auth-1", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateOrderShipmentQuery).
+		WithArgs("SHIPPED", shipment.ShipmentID, shipment.TrackingNumber, shipment.LabelURL, orderID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(cancelShipmentQuery).
+		WithArgs("SHIPMENT_CANCELLED", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateOrderRefundQuery).
+		WithArgs("PAYMENT_FAILED", "refund-1", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.RecordPayment(ctx, orderID, "SHIPPING_INITIATED", "auth-1", "capture-auth-1"); err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if err := store.RecordShipment(ctx, orderID, "SHIPPED", shipment); err != nil {
+		t.Fatalf("RecordShipment() error = %v", err)
+	}
+	if err := store.RecordShipmentCancellation(ctx, orderID, "SHIPMENT_CANCELLED"); err != nil {
+		t.Fatalf("RecordShipmentCancellation() error = %v", err)
+	}
+	if err := store.RecordRefund(ctx, orderID, "PAYMENT_FAILED", "refund-1"); err != nil {
+		t.Fatalf("RecordRefund() error = %v", err)
+	}
+}
+
+func TestPostgresStore_CountOrdersSince(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	userID := uuid.New()
+	since := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(countOrdersSinceQuery).WithArgs(userID, since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	n, err := store.CountOrdersSince(context.Background(), userID, since)
+	if err != nil || n != 3 {
+		t.Fatalf("CountOrdersSince() = %d, %v; want 3, nil", n, err)
+	}
+}
+
+func TestPostgresStore_RecordApproval(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	ctx := context.Background()
+	orderID := uuid.New()
+
+	mock.ExpectExec(recordApprovalQuery).
+		WithArgs(ApprovalRejected, "risk@example.com", "card reported stolen", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.RecordApproval(ctx, orderID, ApprovalRejected, "risk@example.com", "card reported stolen"); err != nil {
+		t.Fatalf("RecordApproval() error = %v", err)
+	}
+
+	mock.ExpectExec(recordApprovalQuery).
+		WithArgs(ApprovalExpired, "", "", orderID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := store.RecordApproval(ctx, orderID, ApprovalExpired, "", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RecordApproval() missing order error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_GetUser(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	userID := uuid.New()
+	mock.ExpectQuery(selectUserQuery).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "email", "address", "blocked", "created_at"}).AddRow("Jane", "jane@example.com", "123 Main St", true, time.Now()))
+
+	user, err := store.GetUser(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if user.Address != "123 Main St" || user.Email != "jane@example.com" || !user.Blocked {
+		t.Errorf("GetUser() = %+v", user)
+	}
+}
+
+func TestPostgresStore_GetUser_NotFound(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	userID := uuid.New()
+	mock.ExpectQuery(selectUserQuery).WithArgs(userID).WillReturnError(sql.ErrNoRows)
+
+	_, err := store.GetUser(context.Background(), userID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetUser() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_MarkInventoryReleased(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	ctx := context.Background()
+	orderID := uuid.New()
+
+	mock.ExpectExec(markReleasedQuery).WithArgs(orderID).WillReturnResult(sqlmock.NewResult(0, 1))
+	marked, err := store.MarkInventoryReleased(ctx, orderID)
+	if err != nil || !marked {
+		t.Fatalf("MarkInventoryReleased() = %v, %v; want true, nil", marked, err)
+	}
+
+	// Already released: the UPDATE matches nothing but the order exists
+	mock.ExpectExec(markReleasedQuery).WithArgs(orderID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectOrderQuery).
+		WithArgs(orderID).
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(uuid.New(), []byte("[]"), 10.0, "INR", []byte("[]"), []byte("[]"), []byte("[]"), false, "CANCELLED", nil, nil, nil, nil, nil, nil, true, false, nil, nil, nil, nil, time.Now()))
+	marked, err = store.MarkInventoryReleased(ctx, orderID)
+	if err != nil || marked {
+		t.Fatalf("MarkInventoryReleased() retry = %v, %v; want false, nil", marked, err)
+	}
+
+	mock.ExpectExec(markReleasedQuery).WithArgs(orderID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectOrderQuery).WithArgs(orderID).WillReturnError(sql.ErrNoRows)
+	if _, err := store.MarkInventoryReleased(ctx, orderID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("MarkInventoryReleased() missing order error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_AddProductStock_RecordsMovement(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	productID := uuid.New()
+	mock.ExpectExec(addProductStockQuery).
+		WithArgs(uuid.NullUUID{UUID: orderID, Valid: true}, productID, -2, MovementOrderReserved, "order-workflow-1", "run-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := store.AddProductStock(context.Background(), InventoryMovement{
+		OrderID:    orderID,
+		ProductID:  productID,
+		Delta:      -2,
+		Reason:     MovementOrderReserved,
+		WorkflowID: "order-workflow-1",
+		RunID:      "run-1",
+	})
+	if err != nil {
+		t.Fatalf("AddProductStock() error = %v", err)
+	}
+}
+
+func TestPostgresStore_AddProductStock_UnknownProduct(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	productID := uuid.New()
+	mock.ExpectExec(addProductStockQuery).
+		WithArgs(uuid.NullUUID{}, productID, 1, MovementAdjustment, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := store.AddProductStock(context.Background(), InventoryMovement{ProductID: productID, Delta: 1, Reason: MovementAdjustment})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("AddProductStock() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_LedgerBalances(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	mock.ExpectQuery(ledgerBalancesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"product_id", "sum"}).AddRow(phoneID, 8).AddRow(caseID, 0))
+
+	balances, err := store.LedgerBalances(context.Background())
+	if err != nil {
+		t.Fatalf("LedgerBalances() error = %v", err)
+	}
+	if balances[phoneID] != 8 || balances[caseID] != 0 || len(balances) != 2 {
+		t.Errorf("LedgerBalances() = %v", balances)
+	}
+}
+
+func TestPostgresStore_AppendStatusHistory(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	mock.ExpectExec(insertStatusHistoryQuery).
+		WithArgs(orderID, sql.NullString{}, StatusAddedToCart, "inventory reserved", "order-workflow-1", "run-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := store.AppendStatusHistory(context.Background(), OrderStatusChange{
+		OrderID:    orderID,
+		ToStatus:   StatusAddedToCart,
+		Reason:     "inventory reserved",
+		WorkflowID: "order-workflow-1",
+		RunID:      "run-1",
+	})
+	if err != nil {
+		t.Fatalf("AppendStatusHistory() error = %v", err)
+	}
+}
+
+func TestPostgresStore_GetCouponForUpdate(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	columns := []string{"type", "percent_off", "amount_off", "currency", "product_id", "buy_quantity", "free_quantity", "max_uses_per_user", "active"}
+	mock.ExpectQuery(selectCouponQuery).
+		WithArgs("FLAT50").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(CouponFixedAmount, nil, "50.00", "INR", nil, nil, nil, 1, true))
+	mock.ExpectQuery(selectCouponQuery).WithArgs("NOSUCH").WillReturnError(sql.ErrNoRows)
+
+	coupon, err := store.GetCouponForUpdate(context.Background(), "FLAT50")
+	if err != nil {
+		t.Fatalf("GetCouponForUpdate() error = %v", err)
+	}
+	want := Coupon{Code: "FLAT50", Type: CouponFixedAmount, AmountOff: inr(5000), MaxUsesPerUser: 1, Active: true}
+	if coupon != want {
+		t.Errorf("GetCouponForUpdate() = %+v, want %+v", coupon, want)
+	}
+	if _, err := store.GetCouponForUpdate(context.Background(), "NOSUCH"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCouponForUpdate() missing coupon error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_ReleaseRedemptions(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	orderID := uuid.New()
+	mock.ExpectExec(releaseRedemptionsQuery).WithArgs(orderID).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	n, err := store.ReleaseRedemptions(context.Background(), orderID)
+	if err != nil || n != 2 {
+		t.Fatalf("ReleaseRedemptions() = %d, %v; want 2, nil", n, err)
+	}
+}
+
+func TestPostgresStore_RecordTax(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	ctx := context.Background()
+	orderID := uuid.New()
+	taxes := []model.TaxLine{{Name: "GST", Rate: "0.18", Taxable: inr(10000), Amount: inr(1800)}}
+	taxesJSON, err := json.Marshal(taxes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectExec(recordTaxQuery).WithArgs(taxesJSON, "118.00", orderID).WillReturnResult(sqlmock.NewResult(0, 1))
+	recorded, err := store.RecordTax(ctx, orderID, taxes, inr(11800))
+	if err != nil || !recorded {
+		t.Fatalf("RecordTax() = %v, %v; want true, nil", recorded, err)
+	}
+
+	mock.ExpectExec(recordTaxQuery).WithArgs(taxesJSON, "118.00", orderID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectOrderQuery).WithArgs(orderID).WillReturnError(sql.ErrNoRows)
+	if _, err := store.RecordTax(ctx, orderID, taxes, inr(11800)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("RecordTax() missing order error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPostgresStore_ListTaxRules(t *testing.T) {
+	store, mock := newPostgresStore(t)
+	mock.ExpectQuery(listTaxRulesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "country", "region", "product_type", "rate"}).
+			AddRow(1, "GST", "India", nil, nil, "0.18000").
+			AddRow(2, "GST", "India", nil, "CLOTHS", "0.05000"))
+
+	rules, err := store.ListTaxRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListTaxRules() error = %v", err)
+	}
+	want := []TaxRule{
+		{ID: 1, Name: "GST", Country: "India", Rate: "0.18000"},
+		{ID: 2, Name: "GST", Country: "India", ProductType: "CLOTHS", Rate: "0.05000"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("ListTaxRules() = %+v, want %+v", rules, want)
+	}
+}
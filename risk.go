@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// Risk decisions returned by AssessRiskActivity
+const (
+	RiskApprove = "APPROVE"
+	RiskReview  = "REVIEW"
+	RiskReject  = "REJECT"
+)
+
+// Points added to the risk score by each rule of RulesRiskScorer
+const (
+	riskPointsHighValue   = 40
+	riskPointsElectronics = 20
+	riskPointsVelocity    = 30
+	riskPointsNewAccount  = 20
+)
+
+// RiskRequest is the input of a RiskScorer. TotalPrice is the amount that will be charged.
+type RiskRequest struct {
+	OrderID    uuid.UUID
+	UserID     uuid.UUID
+	Items      []model.OrderItem
+	TotalPrice model.Money
+}
+
+// RiskScore is the result of a RiskScorer: the higher the score, the riskier
+// the order. Reasons explain what contributed to it.
+type RiskScore struct {
+	Score   int
+	Reasons []string
+}
+
+// RiskScorer scores the fraud risk of an order. Implementations wrap a rules
+// engine or an external fraud service.
+type RiskScorer interface {
+	Score(ctx context.Context, req RiskRequest) (RiskScore, error)
+}
+
+// RiskRules configures RulesRiskScorer. A zero value disables the rule.
+type RiskRules struct {
+	// HighValue scores orders whose total is at or above it, converted into
+	// its currency; more when the order contains electronics.
+	HighValue model.Money
+	// VelocityLimit scores users with more than this many orders, the one
+	// being assessed included, placed within VelocityWindow.
+	VelocityLimit  int
+	VelocityWindow time.Duration
+	// NewAccountAge scores users whose account is younger than this.
+	NewAccountAge time.Duration
+}
+
+// RulesRiskScorer is a RiskScorer adding up points for order value, order
+// velocity per user and account age.
+type RulesRiskScorer struct {
+	rules  RiskRules
+	orders OrderRepository
+	users  UserRepository
+	rates  ExchangeRateProvider
+}
+
+// NewRulesRiskScorer returns a RulesRiskScorer reading order history and users
+// from the repositories and converting totals with rates.
+func NewRulesRiskScorer(rules RiskRules, orders OrderRepository, users UserRepository, rates ExchangeRateProvider) *RulesRiskScorer {
+	return &RulesRiskScorer{rules: rules, orders: orders, users: users, rates: rates}
+}
+
+// Score implements RiskScorer.
+func (s *RulesRiskScorer) Score(ctx context.Context, req RiskRequest) (RiskScore, error) {
+	var score RiskScore
+	now := time.Now()
+
+	if !s.rules.HighValue.IsZero() {
+		total := req.TotalPrice
+		if total.Currency != s.rules.HighValue.Currency {
+			rate, err := s.rates.Rate(ctx, total.Currency, s.rules.HighValue.Currency)
+			if err != nil {
+				return RiskScore{}, fmt.Errorf("failed to convert order total: %w", err)
+			}
+			if total, err = rate.Convert(total); err != nil {
+				return RiskScore{}, fmt.Errorf("failed to convert order total: %w", err)
+			}
+		}
+		if cmp, _ := total.Cmp(s.rules.HighValue); cmp >= 0 {
+			score.add(riskPointsHighValue, fmt.Sprintf("order total %s at or above %s", req.TotalPrice, s.rules.HighValue))
+			for _, item := range req.Items {
+				if item.ProductType == "ELECTRONICS" {
+					score.add(riskPointsElectronics, "high-value order contains electronics")
+					break
+				}
+			}
+		}
+	}
+
+	if s.rules.VelocityLimit > 0 && s.rules.VelocityWindow > 0 {
+		n, err := s.orders.CountOrdersSince(ctx, req.UserID, now.Add(-s.rules.VelocityWindow))
+		if err != nil {
+			return RiskScore{}, fmt.Errorf("failed to count recent orders: %w", err)
+		}
+		if n > s.rules.VelocityLimit {
+			score.add(riskPointsVelocity, fmt.Sprintf("%d orders in the last %s", n, s.rules.VelocityWindow))
+		}
+	}
+
+	if s.rules.NewAccountAge > 0 {
+		user, err := s.users.GetUser(ctx, req.UserID)
+		if err != nil {
+			return RiskScore{}, fmt.Errorf("failed to get user %s: %w", req.UserID, err)
+		}
+		if age := now.Sub(user.CreatedAt); age < s.rules.NewAccountAge {
+			score.add(riskPointsNewAccount, fmt.Sprintf("account created %s ago", age.Round(time.Minute)))
+		}
+	}
+	return score, nil
+}
+
+func (s *RiskScore) add(points int, reason string) {
+	s.Score += points
+	s.Reasons = append(s.Reasons, reason)
+}
+
+// riskDecision maps a score to a decision. A threshold of zero disables the decision.
+func riskDecision(score, reviewScore, rejectScore int) string {
+	switch {
+	case rejectScore > 0 && score >= rejectScore:
+		return RiskReject
+	case reviewScore > 0 && score >= reviewScore:
+		return RiskReview
+	}
+	return RiskApprove
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestRulesRiskScorer_Score(t *testing.T) {
+	rules := RiskRules{
+		HighValue:      inr(10000000),
+		VelocityLimit:  2,
+		VelocityWindow: time.Hour,
+		NewAccountAge:  7 * 24 * time.Hour,
+	}
+	rates := &StaticExchangeRates{Rates: map[string]map[string]string{"INR": {"USD": "0.0125"}}}
+	phone := model.OrderItem{ProductID: uuid.New(), Quantity: 1, UnitPrice: inr(12000000), ProductType: "ELECTRONICS"}
+	shirt := model.OrderItem{ProductID: uuid.New(), Quantity: 1, UnitPrice: inr(199900), ProductType: "CLOTHS"}
+
+	tests := []struct {
+		name        string
+		items       []model.OrderItem
+		total       model.Money
+		accountAge  time.Duration
+		recent      int
+		wantScore   int
+		wantReasons int
+	}{
+		{name: "small order from an old account", items: []model.OrderItem{shirt}, total: inr(199900), accountAge: 365 * 24 * time.Hour},
+		{name: "high-value electronics", items: []model.OrderItem{phone}, total: inr(12000000), accountAge: 365 * 24 * time.Hour, wantScore: 60, wantReasons: 2},
+		{name: "high value in another currency", items: []model.OrderItem{shirt}, total: model.NewMoney(125000, "USD"), accountAge: 365 * 24 * time.Hour, wantScore: 40, wantReasons: 1},
+		{name: "new account", items: []model.OrderItem{shirt}, total: inr(199900), accountAge: time.Hour, wantScore: 20, wantReasons: 1},
+		{name: "velocity", items: []model.OrderItem{shirt}, total: inr(199900), accountAge: 365 * 24 * time.Hour, recent: 2, wantScore: 30, wantReasons: 1},
+		{name: "everything", items: []model.OrderItem{phone, shirt}, total: inr(12199900), accountAge: time.Hour, recent: 5, wantScore: 110, wantReasons: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			userID := uuid.New()
+			store.AddUser(User{ID: userID, CreatedAt: time.Now().Add(-tt.accountAge)})
+			// Older orders outside the window never count
+			old := Order{ID: uuid.New(), UserID: userID, TotalPrice: inr(100), CreatedAt: time.Now().Add(-2 * time.Hour)}
+			if err := store.CreateOrder(context.Background(), old); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.recent; i++ {
+				if err := store.CreateOrder(context.Background(), Order{ID: uuid.New(), UserID: userID, TotalPrice: inr(100)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The order being assessed is stored as well
+			orderID := uuid.New()
+			if err := store.CreateOrder(context.Background(), Order{ID: orderID, UserID: userID, Items: tt.items, TotalPrice: tt.total}); err != nil {
+				t.Fatal(err)
+			}
+
+			scorer := NewRulesRiskScorer(rules, store, store, rates)
+			got, err := scorer.Score(context.Background(), RiskRequest{OrderID: orderID, UserID: userID, Items: tt.items, TotalPrice: tt.total})
+			if err != nil {
+				t.Fatalf("Score() error = %v", err)
+			}
+			if got.Score != tt.wantScore || len(got.Reasons) != tt.wantReasons {
+				t.Errorf("Score() = %+v, want score %d with %d reasons", got, tt.wantScore, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestRulesRiskScorer_ZeroRulesScoreNothing(t *testing.T) {
+	store := NewMemoryStore()
+	scorer := NewRulesRiskScorer(RiskRules{}, store, store, &StaticExchangeRates{})
+	got, err := scorer.Score(context.Background(), RiskRequest{UserID: uuid.New(), TotalPrice: inr(99999999)})
+	if err != nil {
+		t.Fatalf("Score() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, RiskScore{}) {
+		t.Errorf("Score() = %+v, want zero", got)
+	}
+}
+
+func TestRiskDecision(t *testing.T) {
+	tests := []struct {
+		score, review, reject int
+		want                  string
+	}{
+		{score: 0, review: 40, reject: 90, want: RiskApprove},
+		{score: 39, review: 40, reject: 90, want: RiskApprove},
+		{score: 40, review: 40, reject: 90, want: RiskReview},
+		{score: 90, review: 40, reject: 90, want: RiskReject},
+		{score: 100, review: 0, reject: 0, want: RiskApprove},
+		{score: 100, review: 40, reject: 0, want: RiskReview},
+	}
+	for _, tt := range tests {
+		if got := riskDecision(tt.score, tt.review, tt.reject); got != tt.want {
+			t.Errorf("riskDecision(%d, %d, %d) = %s, want %s", tt.score, tt.review, tt.reject, got, tt.want)
+		}
+	}
+}
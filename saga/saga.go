@@ -0,0 +1,199 @@
+// Package saga runs the compensations of a workflow implemented as a saga:
+// each completed step registers how to undo itself, and when a later step
+// fails the registered compensations are executed and their outcomes reported.
+package saga
+
+import (
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// States of a compensation reported by Saga.Report
+const (
+	// StatePending is a registered compensation that has not run
+	StatePending = "PENDING"
+	// StateCompleted is a compensation that ran successfully
+	StateCompleted = "COMPLETED"
+	// StateFailed is a compensation that returned an error
+	StateFailed = "FAILED"
+	// StateSkipped is a compensation that was not run because another one
+	// failed under the StopOnError policy
+	StateSkipped = "SKIPPED"
+	// StateCancelled is a parallel compensation cancelled because another one
+	// failed under the StopOnError policy
+	StateCancelled = "CANCELLED"
+	// StateNotNeeded is a compensation that is not run because the saga completed
+	StateNotNeeded = "NOT_NEEDED"
+)
+
+// ErrorPolicy decides what happens to the remaining compensations when one fails.
+type ErrorPolicy int
+
+const (
+	// ContinueOnError runs every compensation, whatever the others return
+	ContinueOnError ErrorPolicy = iota
+	// StopOnError runs no further compensation once one has failed and
+	// cancels the parallel ones still running
+	StopOnError
+)
+
+// Compensation undoes a completed step of the saga.
+type Compensation func(ctx workflow.Context) error
+
+// Options configures a Saga.
+type Options struct {
+	// Parallel runs every compensation at once instead of one after the
+	// other, in reverse order of registration
+	Parallel bool
+	// ErrorPolicy applies when a compensation fails; ContinueOnError by default
+	ErrorPolicy ErrorPolicy
+}
+
+// CompensationOption configures a single compensation.
+type CompensationOption func(*compensation)
+
+// WithActivityOptions sets the activity options of the activities the
+// compensation executes, e.g. a retry policy more patient than the step's.
+func WithActivityOptions(options workflow.ActivityOptions) CompensationOption {
+	return func(c *compensation) {
+		c.activityOptions = &options
+	}
+}
+
+// Outcome is the state of one registered compensation.
+type Outcome struct {
+	Name  string
+	State string
+	Error string `json:",omitempty"`
+}
+
+type compensation struct {
+	name            string
+	fn              Compensation
+	activityOptions *workflow.ActivityOptions
+	outcome         Outcome
+}
+
+// Saga collects the compensations of the completed steps of a workflow. It
+// must only be used from the workflow it is created in.
+type Saga struct {
+	options       Options
+	compensations []*compensation
+}
+
+// New returns an empty Saga.
+func New(options Options) *Saga {
+	return &Saga{options: options}
+}
+
+// AddCompensation registers the compensation of a step that has just completed.
+func (s *Saga) AddCompensation(name string, fn Compensation, opts ...CompensationOption) {
+	c := &compensation{name: name, fn: fn, outcome: Outcome{Name: name, State: StatePending}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	s.compensations = append(s.compensations, c)
+}
+
+// Len returns the number of registered compensations.
+func (s *Saga) Len() int {
+	return len(s.compensations)
+}
+
+// Complete marks every registered compensation as not needed, once the
+// workflow has gone through.
+func (s *Saga) Complete() {
+	for _, c := range s.compensations {
+		c.outcome.State = StateNotNeeded
+	}
+}
+
+// Report returns the outcome of every registered compensation, in order of
+// registration.
+func (s *Saga) Report() []Outcome {
+	report := make([]Outcome, len(s.compensations))
+	for i, c := range s.compensations {
+		report[i] = c.outcome
+	}
+	return report
+}
+
+// Compensate runs the registered compensations according to the options and
+// returns the errors of those that failed, joined. Compensations run on a
+// context disconnected from ctx, so they also run when the workflow itself
+// has been cancelled.
+func (s *Saga) Compensate(ctx workflow.Context) error {
+	ctx, cancel := workflow.NewDisconnectedContext(ctx)
+	defer cancel()
+	if s.options.Parallel {
+		s.compensateParallel(ctx)
+	} else {
+		s.compensateSequential(ctx)
+	}
+
+	var errs []error
+	for _, c := range s.compensations {
+		if c.outcome.State == StateFailed {
+			errs = append(errs, fmt.Errorf("%s: %s", c.name, c.outcome.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compensateSequential runs the compensations in reverse order of registration.
+func (s *Saga) compensateSequential(ctx workflow.Context) {
+	failed := false
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		if failed && s.options.ErrorPolicy == StopOnError {
+			c.outcome.State = StateSkipped
+			continue
+		}
+		if err := c.run(ctx); err != nil {
+			failed = true
+		}
+	}
+}
+
+// compensateParallel runs every compensation at once and waits for them all.
+func (s *Saga) compensateParallel(ctx workflow.Context) {
+	runCtx, cancel := workflow.WithCancel(ctx)
+	defer cancel()
+	stopped := false
+	wg := workflow.NewWaitGroup(ctx)
+	for _, c := range s.compensations {
+		c := c
+		wg.Add(1)
+		workflow.Go(runCtx, func(ctx workflow.Context) {
+			defer wg.Done()
+			err := c.run(ctx)
+			if err == nil || s.options.ErrorPolicy != StopOnError {
+				return
+			}
+			if stopped && temporal.IsCanceledError(err) {
+				c.outcome.State = StateCancelled
+				return
+			}
+			stopped = true
+			cancel()
+		})
+	}
+	wg.Wait(ctx)
+}
+
+// run runs the compensation and records its outcome.
+func (c *compensation) run(ctx workflow.Context) error {
+	if c.activityOptions != nil {
+		ctx = workflow.WithActivityOptions(ctx, *c.activityOptions)
+	}
+	if err := c.fn(ctx); err != nil {
+		c.outcome.State = StateFailed
+		c.outcome.Error = err.Error()
+		return err
+	}
+	c.outcome.State = StateCompleted
+	return nil
+}
@@ -0,0 +1,238 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// testStep is a step whose compensation waits for Sleep and then fails if Fail is set
+type testStep struct {
+	Name  string
+	Sleep time.Duration
+	Fail  bool
+}
+
+// testResult is what compensateWorkflow saw of the compensation
+type testResult struct {
+	Ran     []string
+	Report  []Outcome
+	Elapsed time.Duration
+	Error   string
+}
+
+// compensateWorkflow registers the compensation of every step and runs them.
+// When cancelled is set, the workflow context is cancelled first.
+func compensateWorkflow(ctx workflow.Context, options Options, steps []testStep, cancelled bool) (testResult, error) {
+	s := New(options)
+	var result testResult
+	for _, step := range steps {
+		step := step
+		s.AddCompensation(step.Name, func(ctx workflow.Context) error {
+			result.Ran = append(result.Ran, step.Name)
+			if step.Sleep > 0 {
+				if err := workflow.Sleep(ctx, step.Sleep); err != nil {
+					return err
+				}
+			}
+			if step.Fail {
+				return errors.New("undo failed")
+			}
+			return nil
+		})
+	}
+	if cancelled {
+		var cancel workflow.CancelFunc
+		ctx, cancel = workflow.WithCancel(ctx)
+		cancel()
+	}
+
+	start := workflow.Now(ctx)
+	if err := s.Compensate(ctx); err != nil {
+		result.Error = err.Error()
+	}
+	result.Elapsed = workflow.Now(ctx).Sub(start)
+	result.Report = s.Report()
+	return result, nil
+}
+
+func runCompensateWorkflow(t *testing.T, options Options, steps []testStep, cancelled bool) testResult {
+	t.Helper()
+	env := (&testsuite.WorkflowTestSuite{}).NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(compensateWorkflow)
+	env.ExecuteWorkflow(compensateWorkflow, options, steps, cancelled)
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	var result testResult
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatalf("failed to get workflow result: %v", err)
+	}
+	return result
+}
+
+func TestSaga_Compensate(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     Options
+		steps       []testStep
+		cancelled   bool
+		wantRan     []string
+		wantStates  []string
+		wantElapsed time.Duration
+		wantError   string
+	}{
+		{
+			name:        "sequential in reverse order",
+			steps:       []testStep{{Name: "a", Sleep: time.Minute}, {Name: "b", Sleep: time.Minute}, {Name: "c", Sleep: time.Minute}},
+			wantRan:     []string{"c", "b", "a"},
+			wantStates:  []string{StateCompleted, StateCompleted, StateCompleted},
+			wantElapsed: 3 * time.Minute,
+		},
+		{
+			name:       "sequential continues on error",
+			steps:      []testStep{{Name: "a"}, {Name: "b", Fail: true}, {Name: "c"}},
+			wantRan:    []string{"c", "b", "a"},
+			wantStates: []string{StateCompleted, StateFailed, StateCompleted},
+			wantError:  "b: undo failed",
+		},
+		{
+			name:       "sequential stops on error",
+			options:    Options{ErrorPolicy: StopOnError},
+			steps:      []testStep{{Name: "a"}, {Name: "b", Fail: true}, {Name: "c"}},
+			wantRan:    []string{"c", "b"},
+			wantStates: []string{StateSkipped, StateFailed, StateCompleted},
+			wantError:  "b: undo failed",
+		},
+		{
+			name:        "parallel runs all at once",
+			options:     Options{Parallel: true},
+			steps:       []testStep{{Name: "a", Sleep: time.Minute}, {Name: "b", Sleep: time.Minute, Fail: true}, {Name: "c", Sleep: time.Minute}},
+			wantRan:     []string{"a", "b", "c"},
+			wantStates:  []string{StateCompleted, StateFailed, StateCompleted},
+			wantElapsed: time.Minute,
+			wantError:   "b: undo failed",
+		},
+		{
+			name:        "parallel stops on error",
+			options:     Options{Parallel: true, ErrorPolicy: StopOnError},
+			steps:       []testStep{{Name: "a", Sleep: time.Hour}, {Name: "b", Sleep: time.Minute, Fail: true}, {Name: "c", Sleep: time.Hour}},
+			wantRan:     []string{"a", "b", "c"},
+			wantStates:  []string{StateCancelled, StateFailed, StateCancelled},
+			wantElapsed: time.Minute,
+			wantError:   "b: undo failed",
+		},
+		{
+			name:        "runs when the workflow is cancelled",
+			steps:       []testStep{{Name: "a", Sleep: time.Minute}},
+			cancelled:   true,
+			wantRan:     []string{"a"},
+			wantStates:  []string{StateCompleted},
+			wantElapsed: time.Minute,
+		},
+		{
+			name: "nothing registered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runCompensateWorkflow(t, tt.options, tt.steps, tt.cancelled)
+
+			if !reflect.DeepEqual(got.Ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", got.Ran, tt.wantRan)
+			}
+			var states []string
+			for i, outcome := range got.Report {
+				if outcome.Name != tt.steps[i].Name {
+					t.Errorf("report[%d].Name = %q, want %q", i, outcome.Name, tt.steps[i].Name)
+				}
+				states = append(states, outcome.State)
+			}
+			if !reflect.DeepEqual(states, tt.wantStates) {
+				t.Errorf("states %v, want %v", states, tt.wantStates)
+			}
+			if got.Elapsed != tt.wantElapsed {
+				t.Errorf("took %v, want %v", got.Elapsed, tt.wantElapsed)
+			}
+			if got.Error != tt.wantError {
+				t.Errorf("error %q, want %q", got.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+// activityOptionsWorkflow compensates two steps with an always failing
+// activity, the second with activity options allowing three attempts.
+func activityOptionsWorkflow(ctx workflow.Context) ([]Outcome, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+	})
+	s := New(Options{})
+	undo := func(ctx workflow.Context) error {
+		return workflow.ExecuteActivity(ctx, "Undo").Get(ctx, nil)
+	}
+	s.AddCompensation("default", undo)
+	s.AddCompensation("patient", undo, WithActivityOptions(workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 3, InitialInterval: time.Second},
+	}))
+	_ = s.Compensate(ctx)
+	return s.Report(), nil
+}
+
+func TestSaga_WithActivityOptions(t *testing.T) {
+	env := (&testsuite.WorkflowTestSuite{}).NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(activityOptionsWorkflow)
+	attempts := 0
+	env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		attempts++
+		return errors.New("undo failed")
+	}, activity.RegisterOptions{Name: "Undo"})
+	env.ExecuteWorkflow(activityOptionsWorkflow)
+
+	var report []Outcome
+	if err := env.GetWorkflowResult(&report); err != nil {
+		t.Fatalf("failed to get workflow result: %v", err)
+	}
+	// The patient compensation runs first and is attempted three times
+	if attempts != 4 {
+		t.Errorf("%d attempts, want 4", attempts)
+	}
+	for _, outcome := range report {
+		if outcome.State != StateFailed || !strings.Contains(outcome.Error, "undo failed") {
+			t.Errorf("%s: %+v, want it failed", outcome.Name, outcome)
+		}
+	}
+}
+
+func TestSaga_CompleteAndReport(t *testing.T) {
+	s := New(Options{})
+	if s.Len() != 0 || len(s.Report()) != 0 {
+		t.Fatalf("new saga has %d compensations, report %v", s.Len(), s.Report())
+	}
+	noop := func(ctx workflow.Context) error { return nil }
+	s.AddCompensation("a", noop)
+	s.AddCompensation("b", noop)
+
+	want := []Outcome{{Name: "a", State: StatePending}, {Name: "b", State: StatePending}}
+	if got := s.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Report() = %v, want %v", got, want)
+	}
+	s.Complete()
+	want = []Outcome{{Name: "a", State: StateNotNeeded}, {Name: "b", State: StateNotNeeded}}
+	if got := s.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Report() after Complete = %v, want %v", got, want)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", s.Len())
+	}
+}
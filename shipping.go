@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// ErrShipmentRejected is returned by a ShippingCarrier that refuses to book a shipment.
+var ErrShipmentRejected = errors.New("shipment rejected by carrier")
+
+// ShippingCarrier books and cancels shipments with a carrier.
+type ShippingCarrier interface {
+	// CreateShipment books a shipment for the order and returns its tracking
+	// details. OrderID is used as the idempotency key, so booking the same order
+	// twice returns the same shipment.
+	CreateShipment(ctx context.Context, req ShipmentRequest) (Shipment, error)
+	// CancelShipment cancels a booked shipment. Cancelling a shipment that is
+	// already cancelled succeeds.
+	CancelShipment(ctx context.Context, shipmentID string) error
+}
+
+// ShipmentRequest is the input to ShippingCarrier.CreateShipment
+type ShipmentRequest struct {
+	OrderID uuid.UUID
+	UserID  uuid.UUID
+	Address string
+	Items   []model.OrderItem
+}
+
+// Shipment is a shipment booked with a carrier
+type Shipment struct {
+	ShipmentID     string
+	TrackingNumber string
+	LabelURL       string
+}
+
+// FakeShippingCarrier is an in-process ShippingCarrier for tests and local runs.
+type FakeShippingCarrier struct {
+	// RejectAll rejects every shipment
+	RejectAll bool
+	// Latency is waited before every call
+	Latency time.Duration
+
+	mu        sync.Mutex
+	seq       int
+	shipments map[uuid.UUID]Shipment
+	cancelled map[string]bool
+}
+
+// NewFakeShippingCarrier returns a FakeShippingCarrier that books every shipment immediately.
+func NewFakeShippingCarrier() *FakeShippingCarrier {
+	return &FakeShippingCarrier{}
+}
+
+// CreateShipment implements ShippingCarrier.
+func (c *FakeShippingCarrier) CreateShipment(ctx context.Context, req ShipmentRequest) (Shipment, error) {
+	if err := c.wait(ctx); err != nil {
+		return Shipment{}, err
+	}
+	if c.RejectAll {
+		return Shipment{}, fmt.Errorf("%w: order %s", ErrShipmentRejected, req.OrderID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	if shipment, ok := c.shipments[req.OrderID]; ok {
+		return shipment, nil
+	}
+	c.seq++
+	shipment := Shipment{
+		ShipmentID:     fmt.Sprintf("shipment-%d", c.seq),
+		TrackingNumber: fmt.Sprintf("FAKE%010d", c.seq),
+		LabelURL:       fmt.Sprintf("https://carrier.invalid/labels/shipment-%d.pdf", c.seq),
+	}
+	c.shipments[req.OrderID] = shipment
+	return shipment, nil
+}
+
+// CancelShipment implements ShippingCarrier.
+func (c *FakeShippingCarrier) CancelShipment(ctx context.Context, shipmentID string) error {
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	for _, shipment := range c.shipments {
+		if shipment.ShipmentID == shipmentID {
+			c.cancelled[shipmentID] = true
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown shipment %s", shipmentID)
+}
+
+// Cancelled reports whether the shipment has been cancelled.
+func (c *FakeShippingCarrier) Cancelled(shipmentID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled[shipmentID]
+}
+
+func (c *FakeShippingCarrier) wait(ctx context.Context) error {
+	if c.Latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(c.Latency):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shipping carrier timed out: %w", ctx.Err())
+	}
+}
+
+func (c *FakeShippingCarrier) init() {
+	if c.shipments == nil {
+		c.shipments = make(map[uuid.UUID]Shipment)
+		c.cancelled = make(map[string]bool)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFakeShippingCarrier_CreateShipmentIsIdempotentPerOrder(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeShippingCarrier()
+	orderID := uuid.New()
+
+	first, err := c.CreateShipment(ctx, ShipmentRequest{OrderID: orderID})
+	if err != nil {
+		t.Fatalf("CreateShipment() error = %v", err)
+	}
+	if first.TrackingNumber == "" || first.LabelURL == "" {
+		t.Errorf("CreateShipment() = %+v, want tracking number and label", first)
+	}
+	again, err := c.CreateShipment(ctx, ShipmentRequest{OrderID: orderID})
+	if err != nil {
+		t.Fatalf("CreateShipment() retry error = %v", err)
+	}
+	if again != first {
+		t.Errorf("CreateShipment() retry = %+v, want %+v", again, first)
+	}
+	other, err := c.CreateShipment(ctx, ShipmentRequest{OrderID: uuid.New()})
+	if err != nil {
+		t.Fatalf("CreateShipment() other order error = %v", err)
+	}
+	if other.TrackingNumber == first.TrackingNumber {
+		t.Errorf("CreateShipment() reused tracking number %q for a different order", other.TrackingNumber)
+	}
+}
+
+func TestFakeShippingCarrier_RejectAll(t *testing.T) {
+	c := &FakeShippingCarrier{RejectAll: true}
+	_, err := c.CreateShipment(context.Background(), ShipmentRequest{OrderID: uuid.New()})
+	if !errors.Is(err, ErrShipmentRejected) {
+		t.Errorf("CreateShipment() error = %v, want ErrShipmentRejected", err)
+	}
+}
+
+func TestFakeShippingCarrier_CancelShipment(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeShippingCarrier()
+	shipment, err := c.CreateShipment(ctx, ShipmentRequest{OrderID: uuid.New()})
+	if err != nil {
+		t.Fatalf("CreateShipment() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.CancelShipment(ctx, shipment.ShipmentID); err != nil {
+			t.Fatalf("CancelShipment() call %d error = %v", i+1, err)
+		}
+	}
+	if !c.Cancelled(shipment.ShipmentID) {
+		t.Error("Cancelled() = false after CancelShipment")
+	}
+	if err := c.CancelShipment(ctx, "shipment-missing"); err == nil {
+		t.Error("CancelShipment() with unknown shipment succeeded, want error")
+	}
+}
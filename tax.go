@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+// TaxableLine is an order line as seen by a TaxCalculator. Amount is the line
+// total after its share of the order discounts.
+type TaxableLine struct {
+	ProductID   uuid.UUID
+	ProductType string
+	Amount      model.Money
+}
+
+// TaxRequest is the input of a TaxCalculator.
+type TaxRequest struct {
+	OrderID  uuid.UUID
+	Address  string
+	Currency string
+	Lines    []TaxableLine
+}
+
+// TaxCalculator computes the taxes due on an order. Implementations wrap a
+// rules table or an external tax service.
+type TaxCalculator interface {
+	// Calculate returns one line per tax charged, in the request currency.
+	Calculate(ctx context.Context, req TaxRequest) ([]model.TaxLine, error)
+}
+
+// RulesTaxCalculator is a TaxCalculator applying the rules of a TaxRuleRepository.
+//
+// The country of an address is its last comma-separated part and a rule's
+// region matches any other part, both case-insensitively. For every tax name,
+// each line is taxed by the most specific matching rule: one with a region
+// beats one without, then one for the line's product type beats one for every
+// type. Rules with different names add up, e.g. a state and a city tax.
+type RulesTaxCalculator struct {
+	rules TaxRuleRepository
+}
+
+// NewRulesTaxCalculator returns a RulesTaxCalculator reading rules from rules.
+func NewRulesTaxCalculator(rules TaxRuleRepository) *RulesTaxCalculator {
+	return &RulesTaxCalculator{rules: rules}
+}
+
+// Calculate implements TaxCalculator.
+func (c *RulesTaxCalculator) Calculate(ctx context.Context, req TaxRequest) ([]model.TaxLine, error) {
+	rules, err := c.rules.ListTaxRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tax rules: %w", err)
+	}
+	country, regions := splitAddress(req.Address)
+
+	type lineKey struct{ name, rate string }
+	taxable := make(map[lineKey]model.Money)
+	for _, line := range req.Lines {
+		// Most specific matching rule per tax name
+		best := make(map[string]TaxRule)
+		for _, rule := range rules {
+			if !rule.matches(country, regions, line.ProductType) {
+				continue
+			}
+			if current, ok := best[rule.Name]; !ok || rule.specificity() > current.specificity() {
+				best[rule.Name] = rule
+			}
+		}
+		for _, rule := range best {
+			key := lineKey{rule.Name, rule.Rate}
+			sum, ok := taxable[key]
+			if !ok {
+				sum = model.NewMoney(0, req.Currency)
+			}
+			if taxable[key], err = sum.Add(line.Amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	taxes := make([]model.TaxLine, 0, len(taxable))
+	for key, base := range taxable {
+		rate, ok := new(big.Rat).SetString(key.rate)
+		if !ok || rate.Sign() < 0 {
+			return nil, fmt.Errorf("tax %s: invalid rate %q", key.name, key.rate)
+		}
+		// Round once per tax line, not per order line
+		taxes = append(taxes, model.TaxLine{Name: key.name, Rate: key.rate, Taxable: base, Amount: base.MulRat(rate)})
+	}
+	sort.Slice(taxes, func(i, j int) bool {
+		if taxes[i].Name != taxes[j].Name {
+			return taxes[i].Name < taxes[j].Name
+		}
+		return taxes[i].Rate < taxes[j].Rate
+	})
+	return taxes, nil
+}
+
+// matches reports whether the rule applies to a line of productType shipped
+// to an address in country and regions.
+func (r TaxRule) matches(country string, regions []string, productType string) bool {
+	if !strings.EqualFold(r.Country, country) {
+		return false
+	}
+	if r.ProductType != "" && r.ProductType != productType {
+		return false
+	}
+	if r.Region == "" {
+		return true
+	}
+	for _, region := range regions {
+		if strings.EqualFold(r.Region, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity ranks rules matching the same line; higher wins.
+func (r TaxRule) specificity() int {
+	n := 0
+	if r.Region != "" {
+		n += 2
+	}
+	if r.ProductType != "" {
+		n++
+	}
+	return n
+}
+
+// splitAddress returns the country of a free-text address, its last
+// comma-separated part, and the other parts.
+func splitAddress(address string) (string, []string) {
+	parts := strings.Split(address, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts[len(parts)-1], parts[:len(parts)-1]
+}
+
+// taxableLines returns the order lines with the order discounts spread over
+// them in proportion to their totals. The last line takes the rounding
+// remainder, so the lines always add up to the discounted order total.
+func taxableLines(items []model.OrderItem, discounts []model.Discount, currency string) ([]TaxableLine, error) {
+	lines := make([]TaxableLine, len(items))
+	subtotal := model.NewMoney(0, currency)
+	for i, item := range items {
+		lines[i] = TaxableLine{ProductID: item.ProductID, ProductType: item.ProductType, Amount: item.UnitPrice.Mul(int64(item.Quantity))}
+		var err error
+		if subtotal, err = subtotal.Add(lines[i].Amount); err != nil {
+			return nil, err
+		}
+	}
+	discount := model.NewMoney(0, currency)
+	for _, d := range discounts {
+		var err error
+		if discount, err = discount.Add(d.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if discount.IsZero() || subtotal.IsZero() {
+		return lines, nil
+	}
+
+	remaining := discount
+	for i := range lines {
+		share := remaining
+		if i < len(lines)-1 {
+			share = discount.MulRat(big.NewRat(lines[i].Amount.Amount, subtotal.Amount))
+		}
+		var err error
+		if lines[i].Amount, err = lines[i].Amount.Sub(share); err != nil {
+			return nil, err
+		}
+		if remaining, err = remaining.Sub(share); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestRulesTaxCalculator_Calculate(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddTaxRule(TaxRule{Name: "GST", Country: "India", Rate: "0.18"})
+	store.AddTaxRule(TaxRule{Name: "GST", Country: "India", ProductType: "CLOTHS", Rate: "0.05"})
+	store.AddTaxRule(TaxRule{Name: "GST", Country: "India", Region: "Goa", Rate: "0.12"})
+	store.AddTaxRule(TaxRule{Name: "CESS", Country: "India", Region: "Kerala", Rate: "0.01"})
+	calculator := NewRulesTaxCalculator(store)
+
+	phone := TaxableLine{ProductID: uuid.New(), ProductType: "ELECTRONICS", Amount: inr(100000)}
+	shirt := TaxableLine{ProductID: uuid.New(), ProductType: "CLOTHS", Amount: inr(1999)}
+	tests := []struct {
+		name    string
+		address string
+		lines   []TaxableLine
+		want    []model.TaxLine
+	}{
+		{
+			name:    "product type beats country",
+			address: "12 MG Road, Bengaluru, Karnataka, India",
+			lines:   []TaxableLine{phone, shirt},
+			want: []model.TaxLine{
+				{Name: "GST", Rate: "0.05", Taxable: inr(1999), Amount: inr(100)},
+				{Name: "GST", Rate: "0.18", Taxable: inr(100000), Amount: inr(18000)},
+			},
+		},
+		{
+			name:    "region beats product type and other names add up",
+			address: "1 Beach Road, Goa, india",
+			lines:   []TaxableLine{phone, shirt},
+			want: []model.TaxLine{
+				{Name: "GST", Rate: "0.12", Taxable: inr(101999), Amount: inr(12240)},
+			},
+		},
+		{
+			name:    "separate taxes add up",
+			address: "3 Marine Drive, Kochi, Kerala, India",
+			lines:   []TaxableLine{phone},
+			want: []model.TaxLine{
+				{Name: "CESS", Rate: "0.01", Taxable: inr(100000), Amount: inr(1000)},
+				{Name: "GST", Rate: "0.18", Taxable: inr(100000), Amount: inr(18000)},
+			},
+		},
+		{
+			name:    "no rule for the country",
+			address: "123 Main St, Anytown, USA",
+			lines:   []TaxableLine{phone},
+			want:    []model.TaxLine{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculator.Calculate(context.Background(), TaxRequest{Address: tt.address, Currency: "INR", Lines: tt.lines})
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Calculate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaxableLines_SpreadsDiscountsProportionally(t *testing.T) {
+	phoneID := uuid.New()
+	caseID := uuid.New()
+	items := []model.OrderItem{
+		{ProductID: phoneID, Quantity: 1, UnitPrice: inr(20000), ProductType: "ELECTRONICS"},
+		{ProductID: caseID, Quantity: 1, UnitPrice: inr(10000), ProductType: "ELECTRONICS"},
+	}
+	discounts := []model.Discount{{CouponCode: "FLAT100", Type: CouponFixedAmount, Amount: inr(10001)}}
+
+	lines, err := taxableLines(items, discounts, "INR")
+	if err != nil {
+		t.Fatalf("taxableLines() error = %v", err)
+	}
+	// Two thirds of 100.01 is 66.673..., rounded to 66.67; the last line takes the rest
+	want := []TaxableLine{
+		{ProductID: phoneID, ProductType: "ELECTRONICS", Amount: inr(13333)},
+		{ProductID: caseID, ProductType: "ELECTRONICS", Amount: inr(6666)},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("taxableLines() = %+v, want %+v", lines, want)
+	}
+}
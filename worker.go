@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -9,6 +10,14 @@ import (
 )
 
 func startWorker() {
+	// runWorker returns instead of exiting so that its deferred cleanup, such
+	// as closing the database pool, runs before the process exits
+	if err := runWorker(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func runWorker() error {
 	// Load config from environment (e.g. .env.dev or system env)
 	cfg := LoadConfigFromEnv()
 
@@ -18,12 +27,15 @@ func startWorker() {
 		temporalAddress = addr
 	}
 
-	// Create Temporal client
+	// Create Temporal client. Workflow and activity loggers derive from its
+	// logger, so all their lines share one structured sink.
+	logger := NewLogger(os.Stdout, cfg.LogLevel)
 	c, err := client.Dial(client.Options{
 		HostPort: temporalAddress,
+		Logger:   logger,
 	})
 	if err != nil {
-		log.Fatalln("Unable to create temporal client", err)
+		return fmt.Errorf("unable to create temporal client: %w", err)
 	}
 	defer c.Close()
 
@@ -33,19 +45,47 @@ func startWorker() {
 	// Register workflow
 	w.RegisterWorkflow(OrderWorkflow)
 
+	var rates ExchangeRateProvider = &StaticExchangeRates{}
+	if cfg.ExchangeRatesFile != "" {
+		if rates, err = LoadExchangeRatesFile(cfg.ExchangeRatesFile); err != nil {
+			return fmt.Errorf("unable to load exchange rates: %w", err)
+		}
+	}
+
 	// Register activities with config (pass the Activities instance)
-	activities := NewActivities(cfg)
+	activities, err := NewActivities(cfg,
+		WithPaymentGateway(&FakePaymentGateway{
+			DeclineAbove: cfg.FakePaymentDeclineAbove,
+			Latency:      cfg.FakePaymentLatency,
+		}),
+		WithShippingCarrier(&FakeShippingCarrier{
+			Latency: cfg.FakeShippingLatency,
+		}),
+		WithExchangeRates(rates),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to create activities: %w", err)
+	}
+	// The connection pool lives as long as the worker and is closed on shutdown
+	defer activities.Close()
 
+	w.RegisterActivity(activities.ValidateUserActivity)
 	w.RegisterActivity(activities.UpdateInventoryActivity)
 	w.RegisterActivity(activities.ReleaseInventoryActivity)
+	w.RegisterActivity(activities.CalculateTaxActivity)
+	w.RegisterActivity(activities.AssessRiskActivity)
+	w.RegisterActivity(activities.RecordApprovalActivity)
 	w.RegisterActivity(activities.DeductPaymentActivity)
 	w.RegisterActivity(activities.RefundPaymentActivity)
 	w.RegisterActivity(activities.ShippingActivity)
+	w.RegisterActivity(activities.CancelShipmentActivity)
+	w.RegisterActivity(activities.MarkPaymentFailedActivity)
+	w.RegisterActivity(activities.MarkOrderCancelledActivity)
 
 	// Start worker
-	log.Println("Worker started. Press Ctrl+C to exit.")
-	err = w.Run(worker.InterruptCh())
-	if err != nil {
-		log.Fatalln("Unable to start worker", err)
+	logger.Info("Worker started. Press Ctrl+C to exit.", "taskQueue", "order-processing-task-queue")
+	if err := w.Run(worker.InterruptCh()); err != nil {
+		return fmt.Errorf("unable to start worker: %w", err)
 	}
+	return nil
 }
@@ -1,87 +1,506 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"sktemporal/model"
+	"sktemporal/saga"
 
+	"github.com/google/uuid"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
-// CompensationFunc represents a compensation action
-type CompensationFunc func(workflow.Context) error
+// CancelOrderSignal is the signal name used to ask a running OrderWorkflow to cancel the order
+const CancelOrderSignal = "cancel"
 
-// OrderWorkflow orchestrates the order processing workflow using Saga pattern
-func OrderWorkflow(ctx workflow.Context, request model.OrderRequest) (err error) {
+// ApproveOrderSignal and RejectOrderSignal are the signal names used to decide
+// an order waiting for manual approval, with a model.ApprovalRequest
+const (
+	ApproveOrderSignal = "approve"
+	RejectOrderSignal  = "reject"
+)
+
+// OrderStatusQuery is the query name that returns the OrderProgress of an OrderWorkflow
+const OrderStatusQuery = "order-status"
+
+// PendingApprovalQuery is the query name that returns the PendingApproval of an
+// OrderWorkflow, or null when the order is not waiting for approval
+const PendingApprovalQuery = "pending-approval"
+
+// Steps reported by the order status query
+const (
+	StepStarted           = "STARTED"
+	StepValidatingUser    = "VALIDATING_USER"
+	StepUpdatingInventory = "UPDATING_INVENTORY"
+	StepCalculatingTax    = "CALCULATING_TAX"
+	StepAssessingRisk     = "ASSESSING_RISK"
+	StepAwaitingApproval  = "AWAITING_APPROVAL"
+	StepDeductingPayment  = "DEDUCTING_PAYMENT"
+	StepShipping          = "SHIPPING"
+	StepCompensating      = "COMPENSATING"
+	StepCompleted         = "COMPLETED"
+	StepFailed            = "FAILED"
+	StepCancelled         = "CANCELLED"
+	StepRejected          = "REJECTED"
+)
+
+// Change IDs of the versioned changes to OrderWorkflow, passed to
+// workflow.GetVersion. See "Workflow Versioning" in the README.
+const (
+	// refundCancelledPaymentChange refunds the payment of an order cancelled
+	// while the payment activity was running
+	refundCancelledPaymentChange = "refund-cancelled-payment"
+	// markFailedOrderChange gives an order rolled back after a failure a final
+	// status: PAYMENT_FAILED when its payment was declined, CANCELLED otherwise
+	markFailedOrderChange = "mark-failed-order"
+	// ignoreEarlyApprovalChange drops approve and reject signals sent before
+	// the order was held for approval, so they cannot decide a later review
+	ignoreEarlyApprovalChange = "ignore-early-approval"
+)
+
+// Compensation states reported by the order status query
+const (
+	CompensationPending   = saga.StatePending
+	CompensationCompleted = saga.StateCompleted
+	CompensationFailed    = saga.StateFailed
+	CompensationNotNeeded = saga.StateNotNeeded
+)
+
+// PendingApproval describes an order waiting for manual approval. ExpiresAt is
+// nil when the approval does not time out.
+type PendingApproval struct {
+	OrderID     uuid.UUID
+	Score       int
+	Reasons     []string
+	RequestedAt time.Time
+	ExpiresAt   *time.Time `json:",omitempty"`
+}
 
-	fmt.Println("--- OrderWorkflow started ---")
+// OrderProgress is the result of the order status query
+type OrderProgress struct {
+	Step             string
+	InventoryResult  *InventoryResult  `json:",omitempty"`
+	TaxResult        *TaxResult        `json:",omitempty"`
+	RiskResult       *RiskResult       `json:",omitempty"`
+	PendingApproval  *PendingApproval  `json:",omitempty"`
+	ApprovalDecision *ApprovalDecision `json:",omitempty"`
+	PaymentResult    *PaymentResult    `json:",omitempty"`
+	ShippingResult   *ShippingResult   `json:",omitempty"`
+	Compensations    []model.CompensationStatus
+	Cancelled        bool
+	LastError        string `json:",omitempty"`
+	// FailureType is the type of the business error the order failed with,
+	// e.g. InsufficientStock, so callers can tell customers why
+	FailureType string `json:",omitempty"`
+}
+
+// OrderWorkflow orchestrates the order processing workflow using Saga pattern.
+// Orders that fail for a business reason, or are rejected or cancelled by
+// signal, are compensated and complete with the failure in the OrderResult;
+// any other failure is compensated and fails the workflow.
+func OrderWorkflow(ctx workflow.Context, request model.OrderRequest) (result model.OrderResult, err error) {
+	progress := OrderProgress{Step: StepStarted, Compensations: []model.CompensationStatus{}}
+	// Compensations run one by one in reverse order of the steps they undo,
+	// all of them even when one fails
+	sg := saga.New(saga.Options{Parallel: false, ErrorPolicy: saga.ContinueOnError})
+	// Log through logger only: it is tagged with the order and the step and
+	// stays silent while the workflow is replayed
+	logger := newOrderLogger(ctx, newOrderID(workflow.GetInfo(ctx).WorkflowExecution.ID, request), &progress)
+	logger.Info("Order workflow started", "userID", request.UserID, "items", len(request.Items))
+
+	if err := workflow.SetQueryHandler(ctx, OrderStatusQuery, func() (OrderProgress, error) {
+		answer := progress
+		answer.Compensations = compensationStatuses(sg.Report())
+		return answer, nil
+	}); err != nil {
+		return result, err
+	}
+	if err := workflow.SetQueryHandler(ctx, PendingApprovalQuery, func() (*PendingApproval, error) {
+		return progress.PendingApproval, nil
+	}); err != nil {
+		return result, err
+	}
 
 	ao := workflow.ActivityOptions{
 		StartToCloseTimeout: 30 * time.Second,
 		RetryPolicy: &temporal.RetryPolicy{
 			MaximumAttempts: 2, // 1 retry = 2 total attempts
 		},
+		// Wait for a cancelled activity to finish so that its side effects are
+		// known before compensations run
+		WaitForCancellation: true,
 	}
 	ctx = workflow.WithActivityOptions(ctx, ao)
 
-	// Track compensations in reverse order (LIFO - Last In First Out)
-	var compensations []CompensationFunc
+	// Tax, payment and shipping run on stepCtx so that a cancel signal also
+	// interrupts them while they are in flight. The payment and shipping
+	// activities heartbeat on gatewayCtx, which is how the cancellation
+	// reaches them; the other steps are quick and do not heartbeat.
+	stepCtx, cancelSteps := workflow.WithCancel(ctx)
+	gatewayCtx := workflow.WithHeartbeatTimeout(stepCtx, 10*time.Second)
+	var cancelRequest *model.CancelOrderRequest
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		var req model.CancelOrderRequest
+		workflow.GetSignalChannel(ctx, CancelOrderSignal).Receive(ctx, &req)
+		cancelRequest = &req
+		progress.Cancelled = true
+		cancelSteps()
+	})
+
+	var inventoryResult InventoryResult
+	// orderStatus follows the status the activities give the order
+	var orderStatus string
+	// failureMessage describes the business failure of the order, if any
+	var failureMessage string
+	// rejected is set when the order fails the risk assessment or its approval
+	var rejected bool
 
 	// Defer compensation execution if an error occurs
 	defer func() {
-		if err != nil && len(compensations) > 0 {
-			fmt.Println("--- Executing compensations in reverse order ---")
-			// Execute compensations in reverse order (LIFO)
-			for i := len(compensations) - 1; i >= 0; i-- {
-				if compErr := compensations[i](ctx); compErr != nil {
-					workflow.GetLogger(ctx).Error("Compensation failed", "error", compErr)
-					// Continue with other compensations even if one fails
+		defer func() {
+			progress.Compensations = compensationStatuses(sg.Report())
+			result = newOrderResult(progress, orderStatus, failureMessage)
+		}()
+		if err == nil {
+			progress.Step = StepCompleted
+			sg.Complete()
+			logger.Info("Order completed")
+			return
+		}
+		progress.LastError = err.Error()
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && businessErrorTypes[appErr.Type()] {
+			progress.FailureType = appErr.Type()
+			failureMessage = appErr.Message()
+			logger.Info("Order cannot go through", "failureType", progress.FailureType, "reason", failureMessage)
+		} else {
+			logger.Error("Order failed", "error", err)
+		}
+		progress.Step = StepCompensating
+		compensated := true
+		if sg.Len() > 0 {
+			logger.Info("Executing compensations in reverse order", "compensations", sg.Len())
+			if compErr := sg.Compensate(ctx); compErr != nil {
+				compensated = false
+				for _, outcome := range sg.Report() {
+					if outcome.State == CompensationFailed {
+						logger.Error("Compensation failed", "compensation", outcome.Name, "error", outcome.Error)
+					}
 				}
 			}
 		}
+		// Marking the order cancelled must run even when the workflow itself
+		// has been cancelled
+		compCtx, cancelComp := workflow.NewDisconnectedContext(ctx)
+		defer cancelComp()
+		cancelled := cancelRequest != nil || errors.Is(ctx.Err(), workflow.ErrCanceled)
+		progress.Step = StepFailed
+		if cancelled {
+			progress.Cancelled = true
+			progress.Step = StepCancelled
+		}
+		if rejected {
+			progress.Step = StepRejected
+		}
+		markCancelled := cancelled || rejected
+		// Any failure, a business one or a fault such as the risk scorer being
+		// down, leaves the rolled back order with a final status. An order whose
+		// compensations failed keeps its status for an operator to look at,
+		// e.g. a payment that could not be refunded.
+		if !markCancelled && compensated && inventoryResult.OrderID != uuid.Nil &&
+			workflow.GetVersion(ctx, markFailedOrderChange, workflow.DefaultVersion, 1) >= 1 {
+			if progress.FailureType != PaymentDeclinedErrorType {
+				markCancelled = true
+			} else if failErr := workflow.ExecuteActivity(compCtx, "MarkPaymentFailedActivity", inventoryResult.OrderID).Get(compCtx, nil); failErr != nil {
+				logger.Error("Failed to mark order payment failed", "error", failErr)
+			} else {
+				orderStatus = StatusPaymentFailed
+				logger.Info("Order payment failed")
+			}
+		}
+		if markCancelled && inventoryResult.OrderID != uuid.Nil {
+			if cancelErr := workflow.ExecuteActivity(compCtx, "MarkOrderCancelledActivity", inventoryResult.OrderID).Get(compCtx, nil); cancelErr != nil {
+				logger.Error("Failed to mark order cancelled", "error", cancelErr)
+			} else {
+				orderStatus = StatusCancelled
+				logger.Info("Order cancelled")
+			}
+		}
+		// The order is over: report the business failure in the result
+		// rather than failing the workflow
+		if progress.FailureType != "" {
+			err = nil
+		}
 	}()
 
-	fmt.Println("--- Activity Options set ---")
+	// checkCancelled turns a pending cancel request into the workflow error so
+	// that compensations for every completed step are executed. Business
+	// failures of activities are returned as they are, see orderFailure.
+	checkCancelled := func(err error) error {
+		if cancelRequest != nil {
+			return newOrderCancelledError(*cancelRequest)
+		}
+		return orderFailure(err)
+	}
+
+	// Reject orders for unknown, blocked or address-less users before anything
+	// is reserved; the error is not retryable and nothing needs compensating
+	progress.Step = StepValidatingUser
+	err = workflow.ExecuteActivity(ctx, "ValidateUserActivity", request.UserID).Get(ctx, nil)
+	if err != nil {
+		return result, orderFailure(err)
+	}
+
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
 
 	// Activity 1: Update inventory
-	var inventoryResult InventoryResult
+	progress.Step = StepUpdatingInventory
 	err = workflow.ExecuteActivity(ctx, "UpdateInventoryActivity", request).Get(ctx, &inventoryResult)
 	if err != nil {
 		// Activity failed before being added to saga, no compensation needed
-		return err
+		return result, orderFailure(err)
 	}
+	progress.InventoryResult = &inventoryResult
+	orderStatus = StatusAddedToCart
 	// Add compensation step for inventory release
-	compensations = append(compensations, func(ctx workflow.Context) error {
-		return workflow.ExecuteActivity(ctx, "ReleaseInventoryActivity", inventoryResult).Get(ctx, nil)
+	sg.AddCompensation("ReleaseInventoryActivity", func(ctx workflow.Context) error {
+		var released CompensationResult
+		if err := workflow.ExecuteActivity(ctx, "ReleaseInventoryActivity", inventoryResult).Get(ctx, &released); err != nil {
+			return err
+		}
+		if !released.Applied {
+			logger.Info("Inventory was already released")
+		}
+		return nil
 	})
 
-	fmt.Println("--- Inventory updated ---")
+	logger.Info("Inventory updated", "totalPrice", inventoryResult.TotalPrice)
+
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
+
+	// Tax the order before charging it. There is nothing to compensate: the tax
+	// only changes the order row, and the total is charged by the next step.
+	progress.Step = StepCalculatingTax
+	var taxResult TaxResult
+	err = workflow.ExecuteActivity(stepCtx, "CalculateTaxActivity", inventoryResult).Get(ctx, &taxResult)
+	if err != nil {
+		return result, checkCancelled(err)
+	}
+	progress.TaxResult = &taxResult
+
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
+
+	// Score the fraud risk before charging: approved orders go on, risky ones
+	// wait for manual approval and the riskiest are rejected and rolled back
+	progress.Step = StepAssessingRisk
+	var riskResult RiskResult
+	err = workflow.ExecuteActivity(stepCtx, "AssessRiskActivity", inventoryResult).Get(ctx, &riskResult)
+	if err != nil {
+		return result, checkCancelled(err)
+	}
+	progress.RiskResult = &riskResult
+	switch riskResult.Decision {
+	case RiskReject:
+		rejected = true
+		return result, newOrderRejectedError(fmt.Sprintf("risk score %d", riskResult.Score), riskResult)
+	case RiskReview:
+		progress.Step = StepAwaitingApproval
+		// Only a decision made on the pending approval counts; an approve or
+		// reject that arrived before the review was requested is dropped
+		if workflow.GetVersion(ctx, ignoreEarlyApprovalChange, workflow.DefaultVersion, 1) >= 1 {
+			for _, name := range []string{ApproveOrderSignal, RejectOrderSignal} {
+				var req model.ApprovalRequest
+				for workflow.GetSignalChannel(ctx, name).ReceiveAsync(&req) {
+					logger.Warn("Ignoring approval signal sent before review", "signal", name, "approver", req.Approver)
+				}
+			}
+		}
+		pending := PendingApproval{
+			OrderID:     riskResult.OrderID,
+			Score:       riskResult.Score,
+			Reasons:     riskResult.Reasons,
+			RequestedAt: workflow.Now(ctx),
+		}
+		decision := ApprovalDecision{OrderID: riskResult.OrderID}
+		decide := func(outcome string) func(workflow.ReceiveChannel, bool) {
+			return func(c workflow.ReceiveChannel, more bool) {
+				var req model.ApprovalRequest
+				c.Receive(ctx, &req)
+				decision.Decision, decision.Approver, decision.Comment = outcome, req.Approver, req.Comment
+			}
+		}
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(workflow.GetSignalChannel(ctx, ApproveOrderSignal), decide(ApprovalApproved))
+		selector.AddReceive(workflow.GetSignalChannel(ctx, RejectOrderSignal), decide(ApprovalRejected))
+		// The durable timer rejects the order when nobody decides in time
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		if riskResult.ApprovalTimeout > 0 {
+			expiresAt := pending.RequestedAt.Add(riskResult.ApprovalTimeout)
+			pending.ExpiresAt = &expiresAt
+			selector.AddFuture(workflow.NewTimer(timerCtx, riskResult.ApprovalTimeout), func(f workflow.Future) {
+				if f.Get(ctx, nil) == nil {
+					decision.Decision = ApprovalExpired
+				}
+			})
+		}
+		// A cancel signal ends the wait too
+		selector.AddReceive(stepCtx.Done(), func(c workflow.ReceiveChannel, more bool) {})
+		progress.PendingApproval = &pending
+		logger.Info("Order awaiting approval", "score", riskResult.Score, "reasons", riskResult.Reasons)
+		selector.Select(ctx)
+		cancelTimer()
+		progress.PendingApproval = nil
+		if err = checkCancelled(ctx.Err()); err != nil {
+			return result, err
+		}
+
+		progress.ApprovalDecision = &decision
+		logger.Info("Approval decided", "decision", decision.Decision, "approver", decision.Approver)
+		err = workflow.ExecuteActivity(stepCtx, "RecordApprovalActivity", decision).Get(ctx, nil)
+		if err != nil {
+			return result, checkCancelled(err)
+		}
+		switch decision.Decision {
+		case ApprovalRejected:
+			rejected = true
+			return result, newOrderRejectedError(fmt.Sprintf("rejected by %s", decision.Approver), decision)
+		case ApprovalExpired:
+			rejected = true
+			return result, newOrderRejectedError(fmt.Sprintf("not approved within %s", riskResult.ApprovalTimeout), decision)
+		}
+	}
+
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
 
 	// Activity 2: Deduct payment
+	progress.Step = StepDeductingPayment
 	var paymentResult PaymentResult
-	err = workflow.ExecuteActivity(ctx, "DeductPaymentActivity", request, inventoryResult).Get(ctx, &paymentResult)
+	refundPayment := func(ctx workflow.Context) error {
+		var refunded CompensationResult
+		if err := workflow.ExecuteActivity(ctx, "RefundPaymentActivity", paymentResult).Get(ctx, &refunded); err != nil {
+			return err
+		}
+		if !refunded.Applied {
+			logger.Info("Payment was already refunded or never captured")
+			return nil
+		}
+		orderStatus = StatusPaymentFailed
+		return nil
+	}
+	err = workflow.ExecuteActivity(gatewayCtx, "DeductPaymentActivity", request, inventoryResult).Get(ctx, &paymentResult)
 	if err != nil {
+		// A payment cancelled in flight may have been captured before the
+		// cancellation reached it; the refund finds the capture on the order
+		if cancelRequest != nil && workflow.GetVersion(ctx, refundCancelledPaymentChange, workflow.DefaultVersion, 1) >= 1 {
+			paymentResult = PaymentResult{OrderID: inventoryResult.OrderID}
+			sg.AddCompensation("RefundPaymentActivity", refundPayment)
+		}
 		// Error occurred, compensations will be executed by defer
-		return err
+		return result, checkCancelled(err)
 	}
+	progress.PaymentResult = &paymentResult
+	orderStatus = StatusShippingInitiated
 	// Add compensation step for payment refund
-	compensations = append(compensations, func(ctx workflow.Context) error {
-		return workflow.ExecuteActivity(ctx, "RefundPaymentActivity", paymentResult).Get(ctx, nil)
-	})
+	sg.AddCompensation("RefundPaymentActivity", refundPayment)
+
+	logger.Info("Payment deducted", "amountPaid", paymentResult.AmountPaid)
 
-	fmt.Println("--- Payment deducted ---")
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
 
 	// Activity 3: Shipping
-	err = workflow.ExecuteActivity(ctx, "ShippingActivity", request, paymentResult).Get(ctx, nil)
+	progress.Step = StepShipping
+	var shippingResult ShippingResult
+	err = workflow.ExecuteActivity(gatewayCtx, "ShippingActivity", request, paymentResult).Get(ctx, &shippingResult)
 	if err != nil {
 		// Error occurred, compensations will be executed by defer in reverse order
-		return err
+		return result, checkCancelled(err)
 	}
+	progress.ShippingResult = &shippingResult
+	orderStatus = StatusShipped
+	// Add compensation step for shipment cancellation
+	sg.AddCompensation("CancelShipmentActivity", func(ctx workflow.Context) error {
+		if err := workflow.ExecuteActivity(ctx, "CancelShipmentActivity", shippingResult).Get(ctx, nil); err != nil {
+			return err
+		}
+		orderStatus = StatusShipmentCancelled
+		return nil
+	})
 
-	fmt.Println("--- Shipping completed ---")
+	logger.Info("Order shipped", "trackingNumber", shippingResult.TrackingNumber)
+
+	if err = checkCancelled(nil); err != nil {
+		return result, err
+	}
 
 	// All activities succeeded, no compensation needed
-	return nil
+	return result, nil
+}
+
+// compensationStatuses converts the report of the order's saga into the
+// compensation states of OrderProgress and OrderResult.
+func compensationStatuses(report []saga.Outcome) []model.CompensationStatus {
+	statuses := make([]model.CompensationStatus, len(report))
+	for i, outcome := range report {
+		statuses[i] = model.CompensationStatus{Name: outcome.Name, State: outcome.State, Error: outcome.Error}
+	}
+	return statuses
+}
+
+// newOrderResult summarizes the order from the progress of the workflow.
+func newOrderResult(progress OrderProgress, orderStatus, failureMessage string) model.OrderResult {
+	result := model.OrderResult{
+		Status:         orderStatus,
+		Compensations:  progress.Compensations,
+		FailureType:    progress.FailureType,
+		FailureMessage: failureMessage,
+	}
+	if inv := progress.InventoryResult; inv != nil {
+		result.OrderID = inv.OrderID
+		result.TotalPrice = inv.TotalPrice
+	}
+	if tax := progress.TaxResult; tax != nil {
+		result.TotalPrice = tax.TotalPrice
+		result.TaxTotal = tax.TaxTotal
+	}
+	if payment := progress.PaymentResult; payment != nil {
+		result.AmountPaid = payment.AmountPaid
+	}
+	if shipping := progress.ShippingResult; shipping != nil {
+		result.TrackingNumber = shipping.TrackingNumber
+	}
+	return result
+}
+
+// newOrderRejectedError returns the error OrderWorkflow fails with when the
+// order is rejected by the risk assessment, its approver, or the approval timeout
+func newOrderRejectedError(reason string, details interface{}) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("order rejected: %s", reason),
+		OrderRejectedErrorType,
+		nil,
+		details,
+	)
+}
+
+// newOrderCancelledError returns the error OrderWorkflow fails with when the order is cancelled by signal
+func newOrderCancelledError(req model.CancelOrderRequest) error {
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("order cancelled: %s", req.Reason),
+		OrderCancelledErrorType,
+		nil,
+		req,
+	)
 }
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"sktemporal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// errDatabaseDown stands for an infrastructure fault, which activities retry
+var errDatabaseDown = errors.New("database is down")
+
+type OrderWorkflowTestSuite struct {
+	suite.Suite
+	*testsuite.WorkflowTestSuite
+
+	env     *testsuite.TestWorkflowEnvironment
+	orderID uuid.UUID
+	request model.OrderRequest
+	// mocks maps each activity name to the function standing in for it
+	mocks map[string]interface{}
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func TestOrderWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, &OrderWorkflowTestSuite{
+		WorkflowTestSuite: &testsuite.WorkflowTestSuite{},
+	})
+}
+
+// SetupTest mocks every activity with one that succeeds; tests replace the
+// ones they need with onActivity before calling execute.
+func (s *OrderWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.RegisterActivity(&Activities{})
+	s.orderID = uuid.New()
+	s.request = model.OrderRequest{
+		UserID: uuid.New(),
+		Items:  []model.OrderItem{{ProductID: uuid.New(), Quantity: 1}},
+	}
+	s.calls = nil
+	s.mocks = map[string]interface{}{
+		"ValidateUserActivity": func(ctx context.Context, userID uuid.UUID) error {
+			return nil
+		},
+		"UpdateInventoryActivity": func(ctx context.Context, request model.OrderRequest) (InventoryResult, error) {
+			return InventoryResult{OrderID: s.orderID, Items: request.Items, TotalPrice: inr(10000)}, nil
+		},
+		"ReleaseInventoryActivity": func(ctx context.Context, result InventoryResult) (CompensationResult, error) {
+			return CompensationResult{OrderID: result.OrderID, Applied: true}, nil
+		},
+		"CalculateTaxActivity": func(ctx context.Context, result InventoryResult) (TaxResult, error) {
+			return TaxResult{OrderID: result.OrderID, TaxTotal: inr(1800), TotalPrice: inr(11800)}, nil
+		},
+		"AssessRiskActivity": func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+			return RiskResult{OrderID: result.OrderID, Decision: RiskApprove}, nil
+		},
+		"RecordApprovalActivity": func(ctx context.Context, decision ApprovalDecision) error {
+			return nil
+		},
+		"DeductPaymentActivity": func(ctx context.Context, request model.OrderRequest, result InventoryResult) (PaymentResult, error) {
+			return PaymentResult{OrderID: result.OrderID, AmountPaid: inr(11800), CaptureID: "capture-1"}, nil
+		},
+		"RefundPaymentActivity": func(ctx context.Context, result PaymentResult) (CompensationResult, error) {
+			return CompensationResult{OrderID: result.OrderID, Applied: true}, nil
+		},
+		"ShippingActivity": func(ctx context.Context, request model.OrderRequest, result PaymentResult) (ShippingResult, error) {
+			return ShippingResult{OrderID: result.OrderID, ShipmentID: "shipment-1", TrackingNumber: "FAKE0000000001"}, nil
+		},
+		"CancelShipmentActivity": func(ctx context.Context, result ShippingResult) error {
+			return nil
+		},
+		"MarkPaymentFailedActivity": func(ctx context.Context, orderID uuid.UUID) error {
+			return nil
+		},
+		"MarkOrderCancelledActivity": func(ctx context.Context, orderID uuid.UUID) error {
+			return nil
+		},
+	}
+}
+
+func (s *OrderWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+// onActivity replaces the mock of the named activity for the current test.
+func (s *OrderWorkflowTestSuite) onActivity(name string, fn interface{}) {
+	s.Require().Contains(s.mocks, name)
+	s.Require().Equal(reflect.TypeOf(s.mocks[name]), reflect.TypeOf(fn), "mock of %s", name)
+	s.mocks[name] = fn
+}
+
+// execute runs OrderWorkflow against the mocks and returns its result, which
+// is empty when the workflow failed.
+func (s *OrderWorkflowTestSuite) execute() (model.OrderResult, error) {
+	for name, fn := range s.mocks {
+		args := make([]interface{}, reflect.TypeOf(fn).NumIn())
+		for i := range args {
+			args[i] = mock.Anything
+		}
+		s.env.OnActivity(name, args...).Return(s.recorded(name, fn)).Maybe()
+	}
+	s.env.ExecuteWorkflow(OrderWorkflow, s.request)
+	s.Require().True(s.env.IsWorkflowCompleted())
+
+	var result model.OrderResult
+	if err := s.env.GetWorkflowError(); err != nil {
+		return result, err
+	}
+	s.Require().NoError(s.env.GetWorkflowResult(&result))
+	return result, nil
+}
+
+// recorded wraps an activity mock so that every attempt is added to calls.
+func (s *OrderWorkflowTestSuite) recorded(name string, fn interface{}) interface{} {
+	v := reflect.ValueOf(fn)
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		s.mu.Lock()
+		s.calls = append(s.calls, name)
+		s.mu.Unlock()
+		return v.Call(args)
+	}).Interface()
+}
+
+// requireCalls asserts the activity attempts made, in order.
+func (s *OrderWorkflowTestSuite) requireCalls(want ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Require().Equal(want, s.calls)
+}
+
+// progress returns the answer to the order status query.
+func (s *OrderWorkflowTestSuite) progress() OrderProgress {
+	encoded, err := s.env.QueryWorkflow(OrderStatusQuery)
+	s.Require().NoError(err)
+	var progress OrderProgress
+	s.Require().NoError(encoded.Get(&progress))
+	return progress
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_Success_ShipsWithoutCompensating() {
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "DeductPaymentActivity", "ShippingActivity")
+	s.Require().Equal(model.OrderResult{
+		OrderID:        s.orderID,
+		Status:         StatusShipped,
+		TotalPrice:     inr(11800),
+		TaxTotal:       inr(1800),
+		AmountPaid:     inr(11800),
+		TrackingNumber: "FAKE0000000001",
+		Compensations: []model.CompensationStatus{
+			{Name: "ReleaseInventoryActivity", State: CompensationNotNeeded},
+			{Name: "RefundPaymentActivity", State: CompensationNotNeeded},
+			{Name: "CancelShipmentActivity", State: CompensationNotNeeded},
+		},
+	}, result)
+	s.Require().Equal(StepCompleted, s.progress().Step)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_LogsTaggedLinesOnce() {
+	var buf bytes.Buffer
+	s.SetLogger(NewLogger(&buf, "info"))
+	defer s.SetLogger(nil)
+	s.SetupTest()
+
+	_, err := s.execute()
+	s.Require().NoError(err)
+
+	wantOrderID := newOrderID("default-test-workflow-id", s.request).String()
+	wantSteps := map[string]string{
+		"Order workflow started": StepStarted,
+		"Inventory updated":      StepUpdatingInventory,
+		"Payment deducted":       StepDeductingPayment,
+		"Order shipped":          StepShipping,
+		"Order completed":        StepCompleted,
+	}
+	seen := map[string]int{}
+	for _, line := range logLines(s.T(), &buf) {
+		step, ok := wantSteps[line["msg"].(string)]
+		if !ok {
+			continue
+		}
+		// The workflow and run IDs are tagged by the worker, which the test
+		// environment does not do
+		seen[line["msg"].(string)]++
+		s.Equal(wantOrderID, line["OrderID"], line["msg"])
+		s.Equal(step, line["Step"], line["msg"])
+	}
+	for msg := range wantSteps {
+		s.Equal(1, seen[msg], "lines logged as %q", msg)
+	}
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_InvalidUser_StopsBeforeInventory() {
+	s.onActivity("ValidateUserActivity", func(ctx context.Context, userID uuid.UUID) error {
+		return newInvalidUserError(userID, InvalidUserBlocked, "is blocked")
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity")
+	s.Require().Equal(InvalidUserErrorType, result.FailureType)
+	s.Require().Equal(uuid.Nil, result.OrderID)
+	s.Require().Empty(result.Status)
+	s.Require().Empty(result.Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_InventoryFails_NothingToCompensate() {
+	s.onActivity("UpdateInventoryActivity", func(ctx context.Context, request model.OrderRequest) (InventoryResult, error) {
+		return InventoryResult{}, newInsufficientStockError(request.Items[0].ProductID, 0, 1)
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity")
+	s.Require().Equal(InsufficientStockErrorType, result.FailureType)
+	s.Require().Contains(result.FailureMessage, "insufficient stock")
+	s.Require().Empty(result.Compensations)
+	s.Require().Equal(StepFailed, s.progress().Step)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_InventoryFaultRetried_FailsWorkflow() {
+	s.onActivity("UpdateInventoryActivity", func(ctx context.Context, request model.OrderRequest) (InventoryResult, error) {
+		return InventoryResult{}, errDatabaseDown
+	})
+
+	_, err := s.execute()
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), errDatabaseDown.Error())
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "UpdateInventoryActivity")
+	s.Require().Equal(StepFailed, s.progress().Step)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_IdempotencyKeyOfShippedOrder_LeavesItAlone() {
+	store := NewMemoryStore()
+	activities, err := NewActivities(&Config{}, WithRepositories(store.Repositories()))
+	s.Require().NoError(err)
+	productID := s.request.Items[0].ProductID
+	store.AddProduct(Product{ID: productID, ItemsAvailable: 9, Price: inr(10000)})
+	s.request.IdempotencyKey = "cart-42"
+	shipped := Order{
+		ID:         newOrderID("", s.request),
+		UserID:     s.request.UserID,
+		Items:      []model.OrderItem{{ProductID: productID, Quantity: 1, UnitPrice: inr(10000)}},
+		TotalPrice: inr(10000),
+		Status:     StatusShipped,
+		WorkflowID: "order-1",
+	}
+	s.Require().NoError(store.CreateOrder(context.Background(), shipped))
+	s.onActivity("UpdateInventoryActivity", activities.UpdateInventoryActivity)
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity")
+	s.Require().Equal(DuplicateOrderErrorType, result.FailureType)
+	s.Require().Empty(result.Compensations)
+	order, err := store.GetOrder(context.Background(), shipped.ID)
+	s.Require().NoError(err)
+	s.Require().Equal(shipped.Status, order.Status)
+	s.Require().False(order.InventoryReleased)
+	s.Require().Equal(9, store.products[productID].ItemsAvailable)
+	s.Require().Len(store.Movements(), 1)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_TaxFails_ReleasesInventory() {
+	s.onActivity("CalculateTaxActivity", func(ctx context.Context, result InventoryResult) (TaxResult, error) {
+		return TaxResult{}, errDatabaseDown
+	})
+
+	_, err := s.execute()
+	s.Require().Error(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity",
+		"CalculateTaxActivity", "CalculateTaxActivity",
+		"ReleaseInventoryActivity", "MarkOrderCancelledActivity")
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_RiskFault_ReleasesInventoryAndCancels() {
+	s.onActivity("AssessRiskActivity", func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+		return RiskResult{}, errDatabaseDown
+	})
+
+	_, err := s.execute()
+	s.Require().Error(err)
+	s.Require().Contains(err.Error(), errDatabaseDown.Error())
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "AssessRiskActivity",
+		"ReleaseInventoryActivity", "MarkOrderCancelledActivity")
+	s.Require().Equal(StepFailed, s.progress().Step)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_PaymentDeclined_ReleasesInventory() {
+	tests := []struct {
+		name       string
+		oldVersion bool
+		wantCalls  []string
+		wantStatus string
+	}{
+		{
+			name:       "marked payment failed",
+			wantCalls:  []string{"ReleaseInventoryActivity", "MarkPaymentFailedActivity"},
+			wantStatus: StatusPaymentFailed,
+		},
+		{
+			name:       "before the versioned change",
+			oldVersion: true,
+			wantCalls:  []string{"ReleaseInventoryActivity"},
+			wantStatus: StatusAddedToCart,
+		},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			s.SetupTest()
+			if tt.oldVersion {
+				s.env.OnGetVersion(markFailedOrderChange, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+			}
+			s.onActivity("DeductPaymentActivity", func(ctx context.Context, request model.OrderRequest, result InventoryResult) (PaymentResult, error) {
+				return PaymentResult{}, newPaymentDeclinedError(result.OrderID, inr(11800), ErrPaymentDeclined)
+			})
+
+			result, err := s.execute()
+			s.Require().NoError(err)
+
+			calls := append([]string{"ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+				"AssessRiskActivity", "DeductPaymentActivity"}, tt.wantCalls...)
+			s.requireCalls(calls...)
+			s.Require().Equal(PaymentDeclinedErrorType, result.FailureType)
+			s.Require().Equal(tt.wantStatus, result.Status)
+			s.Require().Equal([]model.CompensationStatus{
+				{Name: "ReleaseInventoryActivity", State: CompensationCompleted},
+			}, result.Compensations)
+		})
+	}
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_ShippingFails_CompensatesInReverseOrder() {
+	s.onActivity("ShippingActivity", func(ctx context.Context, request model.OrderRequest, result PaymentResult) (ShippingResult, error) {
+		return ShippingResult{}, newShipmentRejectedError(result.OrderID, ErrShipmentRejected)
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "DeductPaymentActivity", "ShippingActivity",
+		"RefundPaymentActivity", "ReleaseInventoryActivity", "MarkOrderCancelledActivity")
+	s.Require().Equal(ShipmentRejectedErrorType, result.FailureType)
+	s.Require().Equal(StatusCancelled, result.Status)
+	s.Require().Equal([]model.CompensationStatus{
+		{Name: "ReleaseInventoryActivity", State: CompensationCompleted},
+		{Name: "RefundPaymentActivity", State: CompensationCompleted},
+	}, result.Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_CompensationFails_ContinuesWithTheRest() {
+	s.onActivity("ShippingActivity", func(ctx context.Context, request model.OrderRequest, result PaymentResult) (ShippingResult, error) {
+		return ShippingResult{}, newShipmentRejectedError(result.OrderID, ErrShipmentRejected)
+	})
+	s.onActivity("RefundPaymentActivity", func(ctx context.Context, result PaymentResult) (CompensationResult, error) {
+		return CompensationResult{}, errDatabaseDown
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "DeductPaymentActivity", "ShippingActivity",
+		"RefundPaymentActivity", "RefundPaymentActivity", "ReleaseInventoryActivity")
+	s.Require().Equal(StatusShippingInitiated, result.Status)
+	s.Require().Len(result.Compensations, 2)
+	s.Require().Equal(CompensationCompleted, result.Compensations[0].State)
+	s.Require().Equal(CompensationFailed, result.Compensations[1].State)
+	s.Require().Contains(result.Compensations[1].Error, errDatabaseDown.Error())
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_RefundNotApplied_KeepsPaidStatus() {
+	s.onActivity("ShippingActivity", func(ctx context.Context, request model.OrderRequest, result PaymentResult) (ShippingResult, error) {
+		return ShippingResult{}, newShipmentRejectedError(result.OrderID, ErrShipmentRejected)
+	})
+	s.onActivity("RefundPaymentActivity", func(ctx context.Context, result PaymentResult) (CompensationResult, error) {
+		return CompensationResult{OrderID: result.OrderID}, nil
+	})
+	s.onActivity("ReleaseInventoryActivity", func(ctx context.Context, result InventoryResult) (CompensationResult, error) {
+		return CompensationResult{}, errDatabaseDown
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	// Nothing was refunded, so the order is still paid for
+	s.Require().Equal(StatusShippingInitiated, result.Status)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_RiskRejected_CancelsOrder() {
+	s.onActivity("AssessRiskActivity", func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+		return RiskResult{OrderID: result.OrderID, Score: 95, Decision: RiskReject}, nil
+	})
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "ReleaseInventoryActivity", "MarkOrderCancelledActivity")
+	s.Require().Equal(OrderRejectedErrorType, result.FailureType)
+	s.Require().Equal(StatusCancelled, result.Status)
+	s.Require().Equal(StepRejected, s.progress().Step)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_ManualApproval() {
+	tests := []struct {
+		name         string
+		signal       string
+		wantDecision string
+		wantCalls    []string
+		wantFailure  string
+	}{
+		{
+			name:         "approved",
+			signal:       ApproveOrderSignal,
+			wantDecision: ApprovalApproved,
+			wantCalls:    []string{"DeductPaymentActivity", "ShippingActivity"},
+		},
+		{
+			name:         "rejected",
+			signal:       RejectOrderSignal,
+			wantDecision: ApprovalRejected,
+			wantCalls:    []string{"ReleaseInventoryActivity", "MarkOrderCancelledActivity"},
+			wantFailure:  OrderRejectedErrorType,
+		},
+		{
+			name:         "expired",
+			wantDecision: ApprovalExpired,
+			wantCalls:    []string{"ReleaseInventoryActivity", "MarkOrderCancelledActivity"},
+			wantFailure:  OrderRejectedErrorType,
+		},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			s.SetupTest()
+			s.onActivity("AssessRiskActivity", func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+				return RiskResult{OrderID: result.OrderID, Score: 50, Decision: RiskReview, ApprovalTimeout: time.Hour}, nil
+			})
+			var recorded ApprovalDecision
+			s.onActivity("RecordApprovalActivity", func(ctx context.Context, decision ApprovalDecision) error {
+				recorded = decision
+				return nil
+			})
+			s.env.RegisterDelayedCallback(func() {
+				encoded, err := s.env.QueryWorkflow(PendingApprovalQuery)
+				s.Require().NoError(err)
+				var pending *PendingApproval
+				s.Require().NoError(encoded.Get(&pending))
+				s.Require().NotNil(pending)
+				s.Require().Equal(s.orderID, pending.OrderID)
+				s.Require().Equal(pending.RequestedAt.Add(time.Hour), *pending.ExpiresAt)
+				if tt.signal != "" {
+					s.env.SignalWorkflow(tt.signal, model.ApprovalRequest{Approver: "risk@example.com"})
+				}
+			}, time.Minute)
+
+			result, err := s.execute()
+			s.Require().NoError(err)
+
+			calls := append([]string{"ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+				"AssessRiskActivity", "RecordApprovalActivity"}, tt.wantCalls...)
+			s.requireCalls(calls...)
+			s.Require().Equal(tt.wantDecision, recorded.Decision)
+			s.Require().Equal(tt.wantFailure, result.FailureType)
+			s.Require().Nil(s.progress().PendingApproval)
+		})
+	}
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_ApproveBeforeReview_IsIgnored() {
+	tests := []struct {
+		name         string
+		oldVersion   bool
+		wantDecision string
+		wantCalls    []string
+		wantFailure  string
+	}{
+		{
+			name:         "ignored, the approval expires",
+			wantDecision: ApprovalExpired,
+			wantCalls:    []string{"ReleaseInventoryActivity", "MarkOrderCancelledActivity"},
+			wantFailure:  OrderRejectedErrorType,
+		},
+		{
+			name:         "before the versioned change",
+			oldVersion:   true,
+			wantDecision: ApprovalApproved,
+			wantCalls:    []string{"DeductPaymentActivity", "ShippingActivity"},
+		},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			s.SetupTest()
+			if tt.oldVersion {
+				s.env.OnGetVersion(ignoreEarlyApprovalChange, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+			}
+			// The approve signal arrives while the risk is still being assessed
+			s.onActivity("AssessRiskActivity", func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+				s.env.SignalWorkflow(ApproveOrderSignal, model.ApprovalRequest{Approver: "early@example.com"})
+				return RiskResult{OrderID: result.OrderID, Score: 50, Decision: RiskReview, ApprovalTimeout: time.Hour}, nil
+			})
+			var recorded ApprovalDecision
+			s.onActivity("RecordApprovalActivity", func(ctx context.Context, decision ApprovalDecision) error {
+				recorded = decision
+				return nil
+			})
+
+			result, err := s.execute()
+			s.Require().NoError(err)
+
+			calls := append([]string{"ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+				"AssessRiskActivity", "RecordApprovalActivity"}, tt.wantCalls...)
+			s.requireCalls(calls...)
+			s.Require().Equal(tt.wantDecision, recorded.Decision)
+			s.Require().Equal(tt.wantFailure, result.FailureType)
+		})
+	}
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_CancelSignal_CompensatesAndCancels() {
+	// Hold the order for approval so that the cancel signal arrives mid-flight
+	s.onActivity("AssessRiskActivity", func(ctx context.Context, result InventoryResult) (RiskResult, error) {
+		return RiskResult{OrderID: result.OrderID, Decision: RiskReview}, nil
+	})
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CancelOrderSignal, model.CancelOrderRequest{Reason: "changed my mind"})
+	}, time.Minute)
+
+	result, err := s.execute()
+	s.Require().NoError(err)
+
+	s.requireCalls("ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+		"AssessRiskActivity", "ReleaseInventoryActivity", "MarkOrderCancelledActivity")
+	s.Require().Equal(OrderCancelledErrorType, result.FailureType)
+	s.Require().Equal("order cancelled: changed my mind", result.FailureMessage)
+	s.Require().Equal(StatusCancelled, result.Status)
+	progress := s.progress()
+	s.Require().Equal(StepCancelled, progress.Step)
+	s.Require().True(progress.Cancelled)
+}
+
+func (s *OrderWorkflowTestSuite) TestOrderWorkflow_CancelSignalDuringPayment_RefundsAndCancels() {
+	// The cancel signal arrives while the payment is being captured, and the
+	// heartbeat brings the cancellation to the activity. The payment may have
+	// been captured by then, so it is refunded before the inventory is released.
+	tests := []struct {
+		name       string
+		oldVersion bool
+		wantCalls  []string
+	}{
+		{
+			name:      "refunded",
+			wantCalls: []string{"RefundPaymentActivity", "ReleaseInventoryActivity", "MarkOrderCancelledActivity"},
+		},
+		{
+			name:       "before the versioned change",
+			oldVersion: true,
+			wantCalls:  []string{"ReleaseInventoryActivity", "MarkOrderCancelledActivity"},
+		},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			s.SetupTest()
+			if tt.oldVersion {
+				s.env.OnGetVersion(refundCancelledPaymentChange, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+			}
+			s.onActivity("DeductPaymentActivity", func(ctx context.Context, request model.OrderRequest, result InventoryResult) (PaymentResult, error) {
+				s.env.SignalWorkflow(CancelOrderSignal, model.CancelOrderRequest{Reason: "changed my mind"})
+				return PaymentResult{}, temporal.NewCanceledError()
+			})
+			var refunded *PaymentResult
+			s.onActivity("RefundPaymentActivity", func(ctx context.Context, result PaymentResult) (CompensationResult, error) {
+				refunded = &result
+				return CompensationResult{OrderID: result.OrderID, Applied: true}, nil
+			})
+
+			result, err := s.execute()
+			s.Require().NoError(err)
+
+			calls := append([]string{"ValidateUserActivity", "UpdateInventoryActivity", "CalculateTaxActivity",
+				"AssessRiskActivity", "DeductPaymentActivity"}, tt.wantCalls...)
+			s.requireCalls(calls...)
+			if refunded != nil {
+				// Only the order is known; the refund finds the capture on it
+				s.Require().Equal(PaymentResult{OrderID: s.orderID}, *refunded)
+			}
+			s.Require().Equal(OrderCancelledErrorType, result.FailureType)
+			s.Require().Equal(StatusCancelled, result.Status)
+			s.Require().Equal(StepCancelled, s.progress().Step)
+		})
+	}
+}
+
+func TestNewOrderResult(t *testing.T) {
+	orderID := uuid.New()
+	compensations := []model.CompensationStatus{{Name: "ReleaseInventoryActivity", State: CompensationCompleted}}
+	tests := []struct {
+		name     string
+		progress OrderProgress
+		status   string
+		failure  string
+		want     model.OrderResult
+	}{
+		{
+			name:     "no order created",
+			progress: OrderProgress{FailureType: InvalidUserErrorType, Compensations: []model.CompensationStatus{}},
+			failure:  "user does not exist",
+			want: model.OrderResult{
+				Compensations:  []model.CompensationStatus{},
+				FailureType:    InvalidUserErrorType,
+				FailureMessage: "user does not exist",
+			},
+		},
+		{
+			name: "shipped",
+			progress: OrderProgress{
+				InventoryResult: &InventoryResult{OrderID: orderID, TotalPrice: inr(10000)},
+				TaxResult:       &TaxResult{OrderID: orderID, TaxTotal: inr(1800), TotalPrice: inr(11800)},
+				PaymentResult:   &PaymentResult{OrderID: orderID, AmountPaid: inr(11800)},
+				ShippingResult:  &ShippingResult{OrderID: orderID, TrackingNumber: "FAKE0000000001"},
+				Compensations:   compensations,
+			},
+			status: StatusShipped,
+			want: model.OrderResult{
+				OrderID:        orderID,
+				Status:         StatusShipped,
+				TotalPrice:     inr(11800),
+				TaxTotal:       inr(1800),
+				AmountPaid:     inr(11800),
+				TrackingNumber: "FAKE0000000001",
+				Compensations:  compensations,
+			},
+		},
+		{
+			name: "failed before tax",
+			progress: OrderProgress{
+				InventoryResult: &InventoryResult{OrderID: orderID, TotalPrice: inr(10000)},
+				FailureType:     OrderCancelledErrorType,
+				Compensations:   compensations,
+			},
+			status:  StatusCancelled,
+			failure: "order cancelled: changed my mind",
+			want: model.OrderResult{
+				OrderID:        orderID,
+				Status:         StatusCancelled,
+				TotalPrice:     inr(10000),
+				Compensations:  compensations,
+				FailureType:    OrderCancelledErrorType,
+				FailureMessage: "order cancelled: changed my mind",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newOrderResult(tt.progress, tt.status, tt.failure)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newOrderResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}